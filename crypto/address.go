@@ -0,0 +1,120 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+)
+
+// AddressVersion is the first byte of every address string. It makes the
+// strings of the current address format start with "A".
+const AddressVersion byte = 0x17
+
+// AddressLength is the length of an address (the RIPEMD160 hash of the
+// signing key followed by its 4 byte checksum).
+const AddressLength = 24
+
+// Errors returned when an address string can't be parsed
+var (
+	ErrAddressEncoding = errors.New("Address contains characters that aren't base58.")
+	ErrAddressLength   = errors.New("Address is the wrong length.")
+	ErrAddressVersion  = errors.New("Address has an unknown version.")
+	ErrAddressChecksum = errors.New("Address checksum doesn't match.")
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var base58Values [256]int8
+
+func init() {
+	for i := range base58Values {
+		base58Values[i] = -1
+	}
+	for i, v := range base58Alphabet {
+		base58Values[v] = int8(i)
+	}
+}
+
+// AddressToString writes an address (as created by BytesToAddress) as a
+// base58 string prefixed with AddressVersion.
+func AddressToString(address []byte) string {
+	return base58Encode(append([]byte{AddressVersion}, address...))
+}
+
+// StringToAddress parses a string written by AddressToString and verifies
+// its checksum. Addresses written in hex by earlier versions are accepted
+// as well.
+func StringToAddress(address string) ([]byte, error) {
+	if len(address) == 2*AddressLength {
+		if by, err := hex.DecodeString(address); err == nil {
+			return by, checkAddress(by)
+		}
+	}
+
+	by, err := base58Decode(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(by) != AddressLength+1 {
+		return nil, ErrAddressLength
+	} else if by[0] != AddressVersion {
+		return nil, ErrAddressVersion
+	}
+	return by[1:], checkAddress(by[1:])
+}
+
+// Verify the length and checksum of an address
+func checkAddress(address []byte) error {
+	if len(address) != AddressLength {
+		return ErrAddressLength
+	} else if !verifyAddress(address) {
+		return ErrAddressChecksum
+	}
+	return nil
+}
+
+func base58Encode(by []byte) string {
+	n := new(big.Int).SetBytes(by)
+	base := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, base, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+
+	// Leading zero bytes are written as leading ones
+	for _, v := range by {
+		if v != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func base58Decode(s string) ([]byte, error) {
+	n := new(big.Int)
+	base := big.NewInt(58)
+
+	zeros := 0
+	for i := 0; i < len(s); i++ {
+		v := base58Values[s[i]]
+		if v < 0 {
+			return nil, ErrAddressEncoding
+		}
+		if v == 0 && zeros == i {
+			zeros++
+		}
+		n.Mul(n, base)
+		n.Add(n, big.NewInt(int64(v)))
+	}
+
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
@@ -0,0 +1,49 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestAddressString(t *testing.T) {
+	address := BytesToAddress([]byte("signing key"))
+
+	str := AddressToString(address)
+	if str[0] != 'A' {
+		t.Error("Expected address string to start with A, got", str)
+	}
+
+	parsed, err := StringToAddress(str)
+	if err != nil || !bytes.Equal(parsed, address) {
+		t.Error("Unable to parse address string.", err)
+	}
+
+	parsed, err = StringToAddress(hex.EncodeToString(address))
+	if err != nil || !bytes.Equal(parsed, address) {
+		t.Error("Unable to parse hex address.", err)
+	}
+
+	corrupt := append([]byte{}, address...)
+	corrupt[0]++
+
+	tests := []struct {
+		str string
+		err error
+	}{
+		{str[:len(str)-1] + "0", ErrAddressEncoding},
+		{str[:len(str)-2], ErrAddressLength},
+		{"", ErrAddressLength},
+		{base58Encode(append([]byte{AddressVersion + 1}, address...)), ErrAddressVersion},
+		{AddressToString(corrupt), ErrAddressChecksum},
+		{hex.EncodeToString(corrupt), ErrAddressChecksum},
+	}
+	for _, v := range tests {
+		if _, err := StringToAddress(v.str); err != v.err {
+			t.Errorf("Expected %v parsing %q, got %v", v.err, v.str, err)
+		}
+		if VerifyStringAddress(v.str) {
+			t.Errorf("Expected %q not to verify.", v.str)
+		}
+	}
+}
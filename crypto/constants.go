@@ -9,9 +9,15 @@ var EllipticCurve elliptic.Curve = elliptic.P256()
 var RSAPrefix = []byte("AD-RSA")
 var ECDSAPrefix = []byte{3}
 
+var X25519Prefix = []byte("AD-X25519")
+var Ed25519Prefix = []byte{5}
+
 // Seperate Encryption Types
-var EncryptionNone = []byte{0, 0} // "airdispat.ch/crypto/none"
-var EncryptionRSA = []byte{1, 1}  // "airdispat.ch/crypto/rsa2048-aes256"
+var EncryptionNone = []byte{0, 0}      // "airdispat.ch/crypto/none"
+var EncryptionRSA = []byte{1, 1}       // "airdispat.ch/crypto/rsa2048-aes256"
+var EncryptionRSAGCM = []byte{1, 2}    // "airdispat.ch/crypto/rsa2048-aes256-gcm"
+var EncryptionX25519GCM = []byte{2, 2} // "airdispat.ch/crypto/x25519-aes256-gcm"
 
 // Seperate Signing Types
 var SigningECDSA = []byte{0}
+var SigningEd25519 = []byte{1}
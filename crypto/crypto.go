@@ -3,7 +3,6 @@ package crypto
 import (
 	"bytes"
 	"crypto/rand"
-	"encoding/hex"
 )
 
 var Random = rand.Reader
@@ -13,20 +12,18 @@ func generateChecksum(address []byte) []byte {
 	return HashSHA(HashSHA(address))[0:4]
 }
 
-// Hex decode an Address then verify that it is correct, then
-// pass it to the byte verification function
+// Parse an Address string then verify that its checksum is correct
 func VerifyStringAddress(address string) bool {
-	byteAddress, err := hex.DecodeString(string(address))
-	if err != nil {
-		return false
-	}
-
-	return verifyAddress(byteAddress)
+	_, err := StringToAddress(address)
+	return err == nil
 }
 
 // Verify an Airdispatch Address from Bytes by comparing the checksum
 // to the one provided
 func verifyAddress(address []byte) bool {
+	if len(address) <= 4 {
+		return false
+	}
 	location := len(address) - 4
 	checksum := address[location:]
 	rest := address[:location]
@@ -4,11 +4,13 @@
 // included.
 //
 // --- Crypto
+// | - Address    = Base58 Address Strings
 // | - Constants  = Constants used for Encoding and Decoding
 // | - Crypto     = Methods needed for all Crypto Files
 // | - Encoding   = Encoding Keys to Binary (and back again)
 // | - Encryption = AES Encryption and Decryption
 // | - Hash       = SHA256 Hashing
-// | - Signatures = ECDSA Signing
+// | - Signatures = ECDSA and Ed25519 Signing
+// | - Suites     = Registry of Signing and Encryption Suites
 //
 package crypto
@@ -2,7 +2,9 @@ package crypto
 
 import (
 	"bytes"
+	"crypto/ecdh"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"encoding/binary"
 	"errors"
@@ -96,3 +98,74 @@ func BytesToRSA(data []byte) (*rsa.PublicKey, error) {
 	}
 	return theKey, nil
 }
+
+// This function writes an Ed25519 Public Key to a bytestring.
+func Ed25519ToBytes(key ed25519.PublicKey) []byte {
+	return bytes.Join([][]byte{Ed25519Prefix, key}, nil)
+}
+
+// This function creates an Ed25519 Public Key from a bytestring.
+func BytesToEd25519(data []byte) (ed25519.PublicKey, error) {
+	if len(data) != len(Ed25519Prefix)+ed25519.PublicKeySize {
+		return nil, errors.New("The key is not the correct length.")
+	}
+	if !bytes.HasPrefix(data, Ed25519Prefix) {
+		return nil, errors.New("The key does not contain the correct prefix.")
+	}
+	return ed25519.PublicKey(data[len(Ed25519Prefix):]), nil
+}
+
+// This function writes an X25519 Public Key to a bytestring.
+func X25519ToBytes(key *ecdh.PublicKey) []byte {
+	return bytes.Join([][]byte{X25519Prefix, key.Bytes()}, nil)
+}
+
+// This function creates an X25519 Public Key from a bytestring.
+func BytesToX25519(data []byte) (*ecdh.PublicKey, error) {
+	if !bytes.HasPrefix(data, X25519Prefix) {
+		return nil, errors.New("X25519 Key had the wrong prefix.")
+	}
+	return ecdh.X25519().NewPublicKey(data[len(X25519Prefix):])
+}
+
+// SigningKeyToBytes writes any supported signing public key (ECDSA or
+// Ed25519) to a bytestring. It returns nil for unsupported keys.
+func SigningKeyToBytes(key interface{}) []byte {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return KeyToBytes(k)
+	case ed25519.PublicKey:
+		return Ed25519ToBytes(k)
+	}
+	return nil
+}
+
+// BytesToSigningKey reads a signing public key, using the prefix to
+// determine its type.
+func BytesToSigningKey(data []byte) (interface{}, error) {
+	if bytes.HasPrefix(data, Ed25519Prefix) {
+		return BytesToEd25519(data)
+	}
+	return BytesToKey(data)
+}
+
+// EncryptionKeyToBytes writes any supported encryption public key (RSA or
+// X25519) to a bytestring. It returns nil for unsupported keys.
+func EncryptionKeyToBytes(key interface{}) []byte {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return RSAToBytes(k)
+	case *ecdh.PublicKey:
+		return X25519ToBytes(k)
+	}
+	return nil
+}
+
+// BytesToEncryptionKey reads an encryption public key, using the prefix to
+// determine its type.
+func BytesToEncryptionKey(data []byte) (interface{}, error) {
+	if bytes.HasPrefix(data, X25519Prefix) {
+		return BytesToX25519(data)
+	}
+	return BytesToRSA(data)
+}
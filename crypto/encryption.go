@@ -1,8 +1,11 @@
 package crypto
 
 import (
+	"bytes"
+	"code.google.com/p/go.crypto/scrypt"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
 	"crypto/rsa"
 	"crypto/sha256"
 	"errors"
@@ -24,6 +27,58 @@ func EncryptAESKey(a AESKey, b *rsa.PublicKey) (EncryptedAESKey, error) {
 	return EncryptedAESKey(encryptedKey), nil
 }
 
+// EncryptAESKeyX25519 wraps an AES key for an X25519 public key. An ephemeral
+// key pair is generated, and the shared secret is hashed into an AES-GCM key.
+// The output is the ephemeral public key followed by the sealed AES key.
+func EncryptAESKeyX25519(a AESKey, b *ecdh.PublicKey) (EncryptedAESKey, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(Random)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := ephemeral.ECDH(b)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralPublic := ephemeral.PublicKey().Bytes()
+	sealed, err := encryptAESGCM(a, deriveX25519Key(shared, ephemeralPublic, b.Bytes()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return EncryptedAESKey(bytes.Join([][]byte{ephemeralPublic, sealed}, nil)), nil
+}
+
+// DecryptAESKeyX25519 is the counterpart to EncryptAESKeyX25519.
+func DecryptAESKeyX25519(key *ecdh.PrivateKey, encryptedAesKey []byte) (AESKey, error) {
+	keySize := len(key.PublicKey().Bytes())
+	if len(encryptedAesKey) < keySize {
+		return nil, errors.New("Encrypted key was too short.")
+	}
+
+	ephemeral, err := ecdh.X25519().NewPublicKey(encryptedAesKey[:keySize])
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := key.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	derived := deriveX25519Key(shared, ephemeral.Bytes(), key.PublicKey().Bytes())
+	decryptedKey, err := decryptAESGCM(encryptedAesKey[keySize:], derived, nil)
+	if err != nil {
+		return nil, err
+	}
+	return AESKey(decryptedKey), nil
+}
+
+func deriveX25519Key(shared []byte, ephemeral []byte, recipient []byte) []byte {
+	return HashSHA(bytes.Join([][]byte{shared, ephemeral, recipient}, nil))
+}
+
 func EncryptDataWithRandomAESKey(plaintext []byte) (aesCipher []byte, unencryptedKey AESKey, err error) {
 	var tempKey []byte
 
@@ -41,6 +96,25 @@ func EncryptDataWithRandomAESKey(plaintext []byte) (aesCipher []byte, unencrypte
 	return
 }
 
+// EncryptDataWithRandomGCMKey works like EncryptDataWithRandomAESKey, but
+// uses AES-GCM so that tampering is detected at decryption time.
+func EncryptDataWithRandomGCMKey(plaintext []byte) (aesCipher []byte, unencryptedKey AESKey, err error) {
+	var tempKey []byte
+
+	tempKey, err = generateRandomAESKey(AESKeySize)
+	if err != nil {
+		return
+	}
+	unencryptedKey = AESKey(tempKey)
+
+	aesCipher, err = encryptAESGCM(plaintext, tempKey, nil)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
 func HybridDecryption(rsaKey *rsa.PrivateKey, encryptedAesKey []byte, ciphertext []byte) (plaintext []byte, error error) {
 	decryptedKey, err := rsa.DecryptOAEP(sha256.New(), Random, rsaKey, encryptedAesKey, nil)
 	if err != nil {
@@ -50,6 +124,50 @@ func HybridDecryption(rsaKey *rsa.PrivateKey, encryptedAesKey []byte, ciphertext
 	return decryptAES(ciphertext, decryptedKey)
 }
 
+// HybridDecryptionGCM is the counterpart to EncryptDataWithRandomGCMKey.
+func HybridDecryptionGCM(rsaKey *rsa.PrivateKey, encryptedAesKey []byte, ciphertext []byte) (plaintext []byte, error error) {
+	decryptedKey, err := rsa.DecryptOAEP(sha256.New(), Random, rsaKey, encryptedAesKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptAESGCM(ciphertext, decryptedKey, nil)
+}
+
+// Passphrase Encryption Methods
+
+// Scrypt parameters used to derive keys from passphrases. N is 1 << ScryptLogN.
+var (
+	ScryptLogN = 15
+	ScryptR    = 8
+	ScryptP    = 1
+)
+
+// DeriveKeyFromPassphrase stretches a passphrase into an AES key with scrypt,
+// using an N of 1 << logN.
+func DeriveKeyFromPassphrase(passphrase []byte, salt []byte, logN int, r int, p int) (AESKey, error) {
+	if logN < 1 || logN > 62 {
+		return nil, errors.New("Scrypt N is out of range.")
+	}
+
+	key, err := scrypt.Key(passphrase, salt, 1<<uint(logN), r, p, AESKeySize/8)
+	if err != nil {
+		return nil, err
+	}
+	return AESKey(key), nil
+}
+
+// EncryptWithKeyGCM encrypts the plaintext with AES-GCM under an existing key.
+// The additional data is authenticated but not encrypted, and may be nil.
+func EncryptWithKeyGCM(plaintext []byte, key AESKey, additionalData []byte) ([]byte, error) {
+	return encryptAESGCM(plaintext, key, additionalData)
+}
+
+// DecryptWithKeyGCM is the counterpart to EncryptWithKeyGCM.
+func DecryptWithKeyGCM(ciphertext []byte, key AESKey, additionalData []byte) ([]byte, error) {
+	return decryptAESGCM(ciphertext, key, additionalData)
+}
+
 func generateRandomAESKey(nbits int) ([]byte, error) {
 	b := make([]byte, (nbits / 8))
 	n, err := io.ReadFull(Random, b)
@@ -96,3 +214,47 @@ func decryptAES(ciphertext []byte, key []byte) (plaintext []byte, error error) {
 	stream.XORKeyStream(ciphertext, ciphertext)
 	return ciphertext, nil
 }
+
+func encryptAESGCM(plaintext []byte, key []byte, additionalData []byte) (ciphertext []byte, error error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create Nonce
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(Random, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func decryptAESGCM(ciphertext []byte, key []byte, additionalData []byte) (plaintext []byte, error error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("Ciphertext was too short.")
+	}
+	nonce := ciphertext[:gcm.NonceSize()]
+	ciphertext = ciphertext[gcm.NonceSize():]
+
+	plaintext, err = gcm.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, errors.New("Ciphertext failed authentication.")
+	}
+	return plaintext, nil
+}
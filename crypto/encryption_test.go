@@ -0,0 +1,65 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestGCMHybridEncryption(t *testing.T) {
+	key, err := rsa.GenerateKey(Random, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("hello airdispatch")
+	ciphertext, aesKey, err := EncryptDataWithRandomGCMKey(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encryptedKey, err := EncryptAESKey(aesKey, &key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := HybridDecryptionGCM(key, encryptedKey, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, plaintext) {
+		t.Error("Decrypted plaintext does not match.")
+	}
+
+	// Tampering with the ciphertext must be detected.
+	ciphertext[len(ciphertext)-1] ^= 1
+	if _, err := HybridDecryptionGCM(key, encryptedKey, ciphertext); err == nil {
+		t.Error("Expected tampered ciphertext to fail authentication.")
+	}
+}
+
+func TestLegacyHybridDecryption(t *testing.T) {
+	key, err := rsa.GenerateKey(Random, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("hello airdispatch")
+	ciphertext, aesKey, err := EncryptDataWithRandomAESKey(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encryptedKey, err := EncryptAESKey(aesKey, &key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := HybridDecryption(key, encryptedKey, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, plaintext) {
+		t.Error("Decrypted plaintext does not match.")
+	}
+}
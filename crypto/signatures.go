@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"math/big"
 )
 
@@ -14,3 +15,22 @@ func SignPayload(key *ecdsa.PrivateKey, payload []byte) (r, s *big.Int, err erro
 func VerifyPayload(key *ecdsa.PublicKey, payload []byte, r, s *big.Int) bool {
 	return ecdsa.Verify(key, payload, r, s)
 }
+
+// Encapsulates Ed25519 Signature Generation
+//
+// The signature is split into its R and S halves so that it fits
+// into the same wire format as ECDSA signatures.
+func SignEd25519(key ed25519.PrivateKey, payload []byte) (r, s []byte) {
+	sig := ed25519.Sign(key, payload)
+	return sig[:32], sig[32:]
+}
+
+// Encapsulates Ed25519 Signature Verification
+func VerifyEd25519(key ed25519.PublicKey, payload []byte, r, s []byte) bool {
+	if len(r) != 32 || len(s) != 32 {
+		return false
+	}
+	sig := make([]byte, 0, ed25519.SignatureSize)
+	sig = append(append(sig, r...), s...)
+	return ed25519.Verify(key, payload, sig)
+}
@@ -0,0 +1,246 @@
+package crypto
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"math/big"
+	"sync"
+)
+
+// A SigningSuite implements the signature algorithm identified by a
+// SigningFunc. Keys are passed as interface{} so that a suite can decide
+// which key types it accepts.
+type SigningSuite interface {
+	// Sign signs the payload with a private key, returning both halves of the
+	// signature (R and S for ECDSA).
+	Sign(privateKey interface{}, payload []byte) (r []byte, s []byte, err error)
+	// Verify checks a signature against a public key encoded with KeyToBytes.
+	Verify(publicKey []byte, payload []byte, r []byte, s []byte) bool
+	// KeyToBytes encodes a public key to be sent alongside the signature.
+	KeyToBytes(publicKey interface{}) ([]byte, error)
+}
+
+// An EncryptionSuite implements the hybrid encryption scheme identified by an
+// EncryptionType. The data is encrypted once with a symmetric key, and that
+// key is then wrapped for every recipient.
+type EncryptionSuite interface {
+	// EncryptData encrypts the plaintext with a new random key.
+	EncryptData(plaintext []byte) (ciphertext []byte, key AESKey, err error)
+	// EncryptKey wraps the symmetric key for a recipient's public key.
+	EncryptKey(key AESKey, publicKey interface{}) (EncryptedAESKey, error)
+	// DecryptData unwraps the symmetric key with the recipient's private key
+	// and uses it to decrypt the ciphertext.
+	DecryptData(privateKey interface{}, encryptedKey []byte, ciphertext []byte) ([]byte, error)
+}
+
+var suiteLock sync.RWMutex
+var signingSuites = make(map[string]SigningSuite)
+var encryptionSuites = make(map[string]EncryptionSuite)
+
+func init() {
+	RegisterSigningSuite(SigningECDSA, ecdsaSuite{})
+	RegisterSigningSuite(SigningEd25519, ed25519Suite{})
+
+	RegisterEncryptionSuite(EncryptionRSA, rsaSuite{gcm: false})
+	RegisterEncryptionSuite(EncryptionRSAGCM, rsaSuite{gcm: true})
+	RegisterEncryptionSuite(EncryptionX25519GCM, x25519Suite{})
+}
+
+// SigningFuncForKey returns the SigningFunc of the built-in suite that
+// accepts a signing public key.
+func SigningFuncForKey(publicKey interface{}) ([]byte, error) {
+	switch publicKey.(type) {
+	case *ecdsa.PublicKey:
+		return SigningECDSA, nil
+	case ed25519.PublicKey:
+		return SigningEd25519, nil
+	}
+	return nil, errors.New("No signing suite supports that key.")
+}
+
+// EncryptionTypeForKey returns the EncryptionType of the built-in suite that
+// accepts an encryption public key. Every suite returned uses AES-GCM for the
+// data, so one message may be encrypted for recipients of different types.
+func EncryptionTypeForKey(publicKey interface{}) ([]byte, error) {
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		return EncryptionRSAGCM, nil
+	case *ecdh.PublicKey:
+		return EncryptionX25519GCM, nil
+	}
+	return nil, errors.New("No encryption suite supports that key.")
+}
+
+// RegisterSigningSuite makes a SigningSuite available under a SigningFunc
+// identifier. Registering an identifier twice replaces the older suite.
+func RegisterSigningSuite(id []byte, suite SigningSuite) {
+	suiteLock.Lock()
+	defer suiteLock.Unlock()
+
+	signingSuites[string(id)] = suite
+}
+
+// LookupSigningSuite returns the SigningSuite registered for a SigningFunc.
+func LookupSigningSuite(id []byte) (SigningSuite, error) {
+	suiteLock.RLock()
+	defer suiteLock.RUnlock()
+
+	suite, ok := signingSuites[string(id)]
+	if !ok {
+		return nil, errors.New("No signing suite is registered for that SigningFunc.")
+	}
+	return suite, nil
+}
+
+// RegisterEncryptionSuite makes an EncryptionSuite available under an
+// EncryptionType identifier. Registering an identifier twice replaces the
+// older suite.
+func RegisterEncryptionSuite(id []byte, suite EncryptionSuite) {
+	suiteLock.Lock()
+	defer suiteLock.Unlock()
+
+	encryptionSuites[string(id)] = suite
+}
+
+// LookupEncryptionSuite returns the EncryptionSuite registered for an
+// EncryptionType.
+func LookupEncryptionSuite(id []byte) (EncryptionSuite, error) {
+	suiteLock.RLock()
+	defer suiteLock.RUnlock()
+
+	suite, ok := encryptionSuites[string(id)]
+	if !ok {
+		return nil, errors.New("No encryption suite is registered for that EncryptionType.")
+	}
+	return suite, nil
+}
+
+// ECDSA-P256 Signing Suite
+
+type ecdsaSuite struct{}
+
+func (ecdsaSuite) Sign(privateKey interface{}, payload []byte) ([]byte, []byte, error) {
+	key, ok := privateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("Signing key is not an ECDSA key.")
+	}
+
+	r, s, err := SignPayload(key, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r.Bytes(), s.Bytes(), nil
+}
+
+func (ecdsaSuite) Verify(publicKey []byte, payload []byte, r []byte, s []byte) bool {
+	key, err := BytesToKey(publicKey)
+	if err != nil {
+		return false
+	}
+
+	rSig, sSig := new(big.Int).SetBytes(r), new(big.Int).SetBytes(s)
+	return VerifyPayload(key, payload, rSig, sSig)
+}
+
+func (ecdsaSuite) KeyToBytes(publicKey interface{}) ([]byte, error) {
+	key, ok := publicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("Signing key is not an ECDSA key.")
+	}
+	return KeyToBytes(key), nil
+}
+
+// Ed25519 Signing Suite
+
+type ed25519Suite struct{}
+
+func (ed25519Suite) Sign(privateKey interface{}, payload []byte) ([]byte, []byte, error) {
+	key, ok := privateKey.(ed25519.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("Signing key is not an Ed25519 key.")
+	}
+
+	r, s := SignEd25519(key, payload)
+	return r, s, nil
+}
+
+func (ed25519Suite) Verify(publicKey []byte, payload []byte, r []byte, s []byte) bool {
+	key, err := BytesToEd25519(publicKey)
+	if err != nil {
+		return false
+	}
+	return VerifyEd25519(key, payload, r, s)
+}
+
+func (ed25519Suite) KeyToBytes(publicKey interface{}) ([]byte, error) {
+	key, ok := publicKey.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("Signing key is not an Ed25519 key.")
+	}
+	return Ed25519ToBytes(key), nil
+}
+
+// RSA-OAEP Encryption Suites (AES-CFB for legacy messages, AES-GCM otherwise)
+
+type rsaSuite struct {
+	gcm bool
+}
+
+func (r rsaSuite) EncryptData(plaintext []byte) ([]byte, AESKey, error) {
+	if r.gcm {
+		return EncryptDataWithRandomGCMKey(plaintext)
+	}
+	return EncryptDataWithRandomAESKey(plaintext)
+}
+
+func (r rsaSuite) EncryptKey(key AESKey, publicKey interface{}) (EncryptedAESKey, error) {
+	rsaKey, ok := publicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("Encryption key is not an RSA key.")
+	}
+	return EncryptAESKey(key, rsaKey)
+}
+
+func (r rsaSuite) DecryptData(privateKey interface{}, encryptedKey []byte, ciphertext []byte) ([]byte, error) {
+	rsaKey, ok := privateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("Decryption key is not an RSA key.")
+	}
+
+	if r.gcm {
+		return HybridDecryptionGCM(rsaKey, encryptedKey, ciphertext)
+	}
+	return HybridDecryption(rsaKey, encryptedKey, ciphertext)
+}
+
+// X25519 Encryption Suite (AES-GCM)
+
+type x25519Suite struct{}
+
+func (x25519Suite) EncryptData(plaintext []byte) ([]byte, AESKey, error) {
+	return EncryptDataWithRandomGCMKey(plaintext)
+}
+
+func (x25519Suite) EncryptKey(key AESKey, publicKey interface{}) (EncryptedAESKey, error) {
+	xKey, ok := publicKey.(*ecdh.PublicKey)
+	if !ok {
+		return nil, errors.New("Encryption key is not an X25519 key.")
+	}
+	return EncryptAESKeyX25519(key, xKey)
+}
+
+func (x25519Suite) DecryptData(privateKey interface{}, encryptedKey []byte, ciphertext []byte) ([]byte, error) {
+	xKey, ok := privateKey.(*ecdh.PrivateKey)
+	if !ok {
+		return nil, errors.New("Decryption key is not an X25519 key.")
+	}
+
+	decryptedKey, err := DecryptAESKeyX25519(xKey, encryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	return decryptAESGCM(ciphertext, decryptedKey, nil)
+}
@@ -15,6 +15,8 @@ const (
 	MessageNotFound  Code = 5
 	UnexpectedError  Code = 6
 	InternalError    Code = 7
+	InvalidTimestamp Code = 8
+	ReplayedMessage  Code = 9
 )
 
 var ADSigningError = errors.New("ADSigningError: Message is not properly signed.")
@@ -19,6 +19,12 @@ func (e *Error) Error() string {
 	return fmt.Sprintf("ADError %d: %s", e.Code, e.Description)
 }
 
+// NotFound reports whether the error was sent because an address couldn't
+// be found (see routing.IsNotFound).
+func (e *Error) NotFound() bool {
+	return e.Code == uint32(AddressNotFound)
+}
+
 func (e *Error) Prepare(from *identity.Address) {
 	e.h = message.CreateHeader(from, identity.Public)
 }
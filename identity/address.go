@@ -2,10 +2,7 @@ package identity
 
 import (
 	"bytes"
-	"crypto/ecdsa"
-	"crypto/rsa"
 	"encoding/gob"
-	"encoding/hex"
 
 	"airdispat.ch/crypto"
 )
@@ -17,10 +14,10 @@ type Address struct {
 	Fingerprint []byte
 	// The location of the user's server
 	Location string
-	// The publicKey of the user
-	EncryptionKey *rsa.PublicKey
-	// The signingKey of the user
-	SigningKey *ecdsa.PublicKey
+	// The publicKey of the user (*rsa.PublicKey or *ecdh.PublicKey)
+	EncryptionKey interface{}
+	// The signingKey of the user (*ecdsa.PublicKey or ed25519.PublicKey)
+	SigningKey interface{}
 
 	// Optional Alias of the Address
 	Alias string
@@ -36,14 +33,17 @@ var Public *Address = &Address{
 }
 
 func (a *Address) generateFingerprint() {
-	by := crypto.KeyToBytes(a.SigningKey)
+	by := crypto.SigningKeyToBytes(a.SigningKey)
 	a.Fingerprint = crypto.BytesToAddress(by)
 }
 
-// The string representation of an Address is just
-// the Fingerprint of that address.
+// The string representation of an Address is the
+// Fingerprint of that address in base58.
 func (a *Address) String() string {
-	return hex.EncodeToString(a.Fingerprint)
+	if len(a.Fingerprint) == 0 {
+		return ""
+	}
+	return crypto.AddressToString(a.Fingerprint)
 }
 
 // Compares the Address to the `Public Address`.
@@ -51,16 +51,23 @@ func (a *Address) IsPublic() bool {
 	return (a == Public)
 }
 
+// HasLocation reports whether the location and keys of the address came
+// from a Router lookup that has been cached.
 func (a *Address) HasLocation() bool {
 	return a.cached
 }
 
+// Cache marks the address as coming from a cached Router lookup.
+func (a *Address) Cache() {
+	a.cached = true
+}
+
 func (a *Address) CanSend() bool {
 	return a.EncryptionKey != nil
 }
 
 func (a *Address) EqualsBytes(addr []byte) bool {
-	return hex.EncodeToString(addr) == a.String()
+	return bytes.Equal(addr, a.Fingerprint)
 }
 
 func CreateAddressFromBytes(b []byte) *Address {
@@ -70,12 +77,14 @@ func CreateAddressFromBytes(b []byte) *Address {
 	}
 }
 
-func CreateAddressFromString(addr string) *Address {
-	by, err := hex.DecodeString(addr)
+// CreateAddressFromString parses the string representation of an Address,
+// returning one of the crypto.ErrAddress errors if it isn't valid.
+func CreateAddressFromString(addr string) (*Address, error) {
+	by, err := crypto.StringToAddress(addr)
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return CreateAddressFromBytes(by)
+	return CreateAddressFromBytes(by), nil
 }
 
 // Encoding for Addresses for easy serialization.
@@ -88,13 +97,13 @@ type encodedAddress struct {
 }
 
 func (a *Address) Encode() ([]byte, error) {
-	rsa := crypto.RSAToBytes(a.EncryptionKey)
-	ecdsa := crypto.KeyToBytes(a.SigningKey)
+	encryption := crypto.EncryptionKeyToBytes(a.EncryptionKey)
+	signing := crypto.SigningKeyToBytes(a.SigningKey)
 
 	b := &bytes.Buffer{}
 	if err := gob.NewEncoder(b).Encode(&encodedAddress{
-		Encryption: rsa,
-		Signing:    ecdsa,
+		Encryption: encryption,
+		Signing:    signing,
 		Location:   a.Location,
 		Alias:      a.Alias,
 	}); err != nil {
@@ -110,19 +119,19 @@ func DecodeAddress(b []byte) (*Address, error) {
 		return nil, err
 	}
 
-	rsa, err := crypto.BytesToRSA(output.Encryption)
+	encryption, err := crypto.BytesToEncryptionKey(output.Encryption)
 	if err != nil {
 		return nil, err
 	}
 
-	ecdsa, err := crypto.BytesToKey(output.Signing)
+	signing, err := crypto.BytesToSigningKey(output.Signing)
 	if err != nil {
 		return nil, err
 	}
 
 	a := &Address{
-		EncryptionKey: rsa,
-		SigningKey:    ecdsa,
+		EncryptionKey: encryption,
+		SigningKey:    signing,
 		Location:      output.Location,
 		Alias:         output.Alias,
 	}
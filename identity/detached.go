@@ -0,0 +1,188 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"airdispat.ch/crypto"
+	"airdispat.ch/wire"
+	"code.google.com/p/goprotobuf/proto"
+)
+
+var (
+	ErrDetachedSignatureMismatch = errors.New("Detached signature doesn't match the data.")
+	ErrDetachedSignatureSigner   = errors.New("Detached signature wasn't made by that address.")
+)
+
+// Starts every payload signed by a detached signature, so that it can't be
+// mistaken for the data of a message
+var detachedPrefix = []byte("AD-DETACHED")
+
+// DetachedSignature is a signature of a file or stream that is kept apart
+// from the data, such as for signing releases or documents. It carries the
+// key of the signer, so it can be checked against an Address without a
+// Router.
+type DetachedSignature struct {
+	SigningFunc []byte
+	// The public signing key of the signer
+	SigningKey interface{}
+	Timestamp  time.Time
+	R          []byte
+	S          []byte
+}
+
+// SignDetached signs everything read from r.
+func (a *Identity) SignDetached(r io.Reader) (*DetachedSignature, error) {
+	hash, err := hashReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	signingFunc, err := a.SigningFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	suite, err := crypto.LookupSigningSuite(signingFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DetachedSignature{
+		SigningFunc: signingFunc,
+		SigningKey:  a.Address.SigningKey,
+		Timestamp:   time.Unix(time.Now().Unix(), 0),
+	}
+
+	d.R, d.S, err = suite.Sign(a.SigningKey, crypto.HashSHA(d.payload(hash)))
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// SignFile signs the contents of a file.
+func (a *Identity) SignFile(filename string) (*DetachedSignature, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return a.SignDetached(file)
+}
+
+// Signer returns the address of the key that made the signature.
+func (d *DetachedSignature) Signer() *Address {
+	a := &Address{
+		SigningKey: d.SigningKey,
+	}
+	a.generateFingerprint()
+	return a
+}
+
+// Verify checks that the signature was made by signer over everything read
+// from r. Only the fingerprint of signer is needed.
+func (d *DetachedSignature) Verify(r io.Reader, signer *Address) error {
+	if !signer.EqualsBytes(d.Signer().Fingerprint) {
+		return ErrDetachedSignatureSigner
+	}
+
+	hash, err := hashReader(r)
+	if err != nil {
+		return err
+	}
+
+	suite, err := crypto.LookupSigningSuite(d.SigningFunc)
+	if err != nil {
+		return err
+	}
+
+	signingKey, err := suite.KeyToBytes(d.SigningKey)
+	if err != nil {
+		return err
+	}
+
+	if !suite.Verify(signingKey, crypto.HashSHA(d.payload(hash)), d.R, d.S) {
+		return ErrDetachedSignatureMismatch
+	}
+	return nil
+}
+
+// VerifyFile checks that the signature was made by signer over the contents
+// of a file.
+func (d *DetachedSignature) VerifyFile(filename string, signer *Address) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return d.Verify(file, signer)
+}
+
+// The canonical bytes that are signed: the prefix, the length of the
+// SigningFunc and the SigningFunc, the timestamp as 8 big-endian bytes, and
+// the SHA256 hash of the data.
+func (d *DetachedSignature) payload(hash []byte) []byte {
+	b := &bytes.Buffer{}
+	b.Write(detachedPrefix)
+	b.WriteByte(byte(len(d.SigningFunc)))
+	b.Write(d.SigningFunc)
+	binary.Write(b, binary.BigEndian, uint64(d.Timestamp.Unix()))
+	b.Write(hash)
+	return b.Bytes()
+}
+
+func hashReader(r io.Reader) ([]byte, error) {
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
+
+// ToBytes encodes the signature to be stored alongside the data.
+func (d *DetachedSignature) ToBytes() ([]byte, error) {
+	signingKey := crypto.SigningKeyToBytes(d.SigningKey)
+	if signingKey == nil {
+		return nil, errors.New("Detached signature has no signing key.")
+	}
+
+	return proto.Marshal(&wire.DetachedSignature{
+		Signature: &wire.Signature{
+			R:          d.R,
+			S:          d.S,
+			SigningKey: signingKey,
+		},
+		SigningFunc: d.SigningFunc,
+		Timestamp:   proto.Uint64(uint64(d.Timestamp.Unix())),
+	})
+}
+
+// CreateDetachedSignatureFromBytes decodes a signature written by ToBytes.
+func CreateDetachedSignatureFromBytes(by []byte) (*DetachedSignature, error) {
+	unmarsh := &wire.DetachedSignature{}
+	if err := proto.Unmarshal(by, unmarsh); err != nil {
+		return nil, err
+	}
+
+	signature := unmarsh.GetSignature()
+	signingKey, err := crypto.BytesToSigningKey(signature.GetSigningKey())
+	if err != nil {
+		return nil, err
+	}
+
+	return &DetachedSignature{
+		SigningFunc: unmarsh.GetSigningFunc(),
+		SigningKey:  signingKey,
+		Timestamp:   time.Unix(int64(unmarsh.GetTimestamp()), 0),
+		R:           signature.GetR(),
+		S:           signature.GetS(),
+	}, nil
+}
@@ -0,0 +1,56 @@
+package identity
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetachedSignature(t *testing.T) {
+	for _, kind := range []Kind{KindRSA, KindCurve25519} {
+		id, err := CreateIdentityOfKind(kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := CreateIdentityOfKind(kind)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filename := filepath.Join(t.TempDir(), "release.tar")
+		if err := os.WriteFile(filename, []byte("release contents"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		sig, err := id.SignFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		by, err := sig.ToBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err = CreateDetachedSignatureFromBytes(by)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Only the fingerprint of the signer is needed.
+		signer, err := CreateAddressFromString(id.Address.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sig.VerifyFile(filename, signer); err != nil {
+			t.Error("Unable to verify signature.", err)
+		}
+
+		if err := sig.Verify(bytes.NewReader([]byte("tampered contents")), signer); err != ErrDetachedSignatureMismatch {
+			t.Error("Expected tampered data to fail verification, got", err)
+		}
+		if err := sig.VerifyFile(filename, other.Address); err != ErrDetachedSignatureSigner {
+			t.Error("Expected another signer to fail verification, got", err)
+		}
+	}
+}
@@ -2,12 +2,16 @@ package identity
 
 import (
 	"airdispat.ch/crypto"
+	"bytes"
+	"crypto/ecdh"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"encoding/gob"
+	"errors"
 	"io"
+	"io/ioutil"
 	"math/big"
-	"os"
 )
 
 // Keygen Variables
@@ -24,19 +28,34 @@ type encodedRSAKey struct {
 type encodedADKey struct {
 	ECDSA *encodedECDSAKey
 	RSA   *encodedRSAKey
+	// Curve25519 Identities
+	Ed25519 []byte
+	X25519  []byte
 }
 
 // This function writes a Gob-Encoded ADKey to a buffer
 func (a *Identity) GobEncodeKey(buffer io.Writer) (io.Writer, error) {
+	eADKey := encodedADKey{}
+
 	// Encode Signature Key
-	ecdsaKey := a.SigningKey
-	eECDSAKey := &encodedECDSAKey{ecdsaKey.D, ecdsaKey.PublicKey.X, ecdsaKey.PublicKey.Y}
+	switch key := a.SigningKey.(type) {
+	case *ecdsa.PrivateKey:
+		eADKey.ECDSA = &encodedECDSAKey{key.D, key.PublicKey.X, key.PublicKey.Y}
+	case ed25519.PrivateKey:
+		eADKey.Ed25519 = key.Seed()
+	default:
+		return nil, errors.New("Unable to encode unknown signing key.")
+	}
 
 	// Encode Encryption Keys
-	rsaKey := a.EncryptionKey
-	eRSAKey := &encodedRSAKey{rsaKey.D, rsaKey.PublicKey.N, rsaKey.Primes, rsaKey.PublicKey.E}
-
-	eADKey := encodedADKey{eECDSAKey, eRSAKey}
+	switch key := a.EncryptionKey.(type) {
+	case *rsa.PrivateKey:
+		eADKey.RSA = &encodedRSAKey{key.D, key.PublicKey.N, key.Primes, key.PublicKey.E}
+	case *ecdh.PrivateKey:
+		eADKey.X25519 = key.Bytes()
+	default:
+		return nil, errors.New("Unable to encode unknown encryption key.")
+	}
 
 	enc := gob.NewEncoder(buffer)
 	err := enc.Encode(eADKey)
@@ -58,54 +77,69 @@ func GobDecodeKey(buffer io.Reader) (*Identity, error) {
 		return nil, err
 	}
 
-	// Create the ECDSA Key from the Encoded Values
-	newECDSAPublicKey := ecdsa.PublicKey{crypto.EllipticCurve, decodedKey.ECDSA.X, decodedKey.ECDSA.Y}
-	newECDSAKey := ecdsa.PrivateKey{
-		PublicKey: newECDSAPublicKey,
-		D:         decodedKey.ECDSA.D,
+	newADKey := &Identity{}
+
+	if decodedKey.ECDSA != nil {
+		// Create the ECDSA Key from the Encoded Values
+		newECDSAPublicKey := ecdsa.PublicKey{crypto.EllipticCurve, decodedKey.ECDSA.X, decodedKey.ECDSA.Y}
+		newADKey.SigningKey = &ecdsa.PrivateKey{
+			PublicKey: newECDSAPublicKey,
+			D:         decodedKey.ECDSA.D,
+		}
+	} else if len(decodedKey.Ed25519) == ed25519.SeedSize {
+		// Create the Ed25519 Key from the Seed
+		newADKey.SigningKey = ed25519.NewKeyFromSeed(decodedKey.Ed25519)
+	} else {
+		return nil, errors.New("Key file does not contain a signing key.")
 	}
 
-	// Create the RSA Key from the Encoded Values
-	newRSAPublicKey := rsa.PublicKey{decodedKey.RSA.N, decodedKey.RSA.E}
-	newRSAKey := rsa.PrivateKey{
-		PublicKey: newRSAPublicKey,
-		D:         decodedKey.RSA.D,
-		Primes:    decodedKey.RSA.P,
+	if decodedKey.RSA != nil {
+		// Create the RSA Key from the Encoded Values
+		newRSAPublicKey := rsa.PublicKey{decodedKey.RSA.N, decodedKey.RSA.E}
+		newADKey.EncryptionKey = &rsa.PrivateKey{
+			PublicKey: newRSAPublicKey,
+			D:         decodedKey.RSA.D,
+			Primes:    decodedKey.RSA.P,
+		}
+	} else if decodedKey.X25519 != nil {
+		// Create the X25519 Key from the Encoded Values
+		newADKey.EncryptionKey, err = ecdh.X25519().NewPrivateKey(decodedKey.X25519)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, errors.New("Key file does not contain an encryption key.")
 	}
 
 	// Reconstruct the Whole Key
-	newADKey := &Identity{
-		SigningKey:    &newECDSAKey,
-		EncryptionKey: &newRSAKey,
-	}
 	newADKey.PopulateAddress()
 
 	return newADKey, nil
 }
 
-// This function Loads an Airdispatch Key from a File
+// This function Loads an unencrypted Airdispatch Key from a File
 func LoadKeyFromFile(filename string) (*Identity, error) {
 	// Open the File for Loading
-	file, err := os.Open(filename)
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	return GobDecodeKey(file)
+	if isEncryptedKey(data) {
+		return nil, ErrKeystoreEncrypted
+	}
+
+	return GobDecodeKey(bytes.NewReader(data))
 }
 
-// This function Saves an Airdispatch Key to a File
+// This function Saves an unencrypted Airdispatch Key to a File that is only
+// readable by its owner. Prefer SaveKeyToFileWithPassphrase.
 func (a *Identity) SaveKeyToFile(filename string) error {
-	// Create the File to Store the Keys in
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-
-	_, err = a.GobEncodeKey(file)
+	buffer := &bytes.Buffer{}
+	_, err := a.GobEncodeKey(buffer)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return writeKeyFile(filename, buffer.Bytes())
 }
@@ -7,51 +7,124 @@ package identity
 import (
 	"airdispat.ch/crypto"
 	"airdispat.ch/wire"
+	"crypto/ecdh"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
+	"errors"
+)
+
+// Kind describes which algorithms an Identity uses for encryption and
+// signing.
+type Kind int
+
+const (
+	// KindRSA identities use RSA-2048 for encryption and ECDSA-P256
+	// for signing.
+	KindRSA Kind = iota
+	// KindCurve25519 identities use X25519 for encryption and Ed25519
+	// for signing. They are much faster to create and have smaller keys.
+	KindCurve25519
 )
 
 // The Identity structure is a complete AirDispatch user
 // including Encryption and Signing private keys.
 //
+// The EncryptionKey is either an *rsa.PrivateKey or an *ecdh.PrivateKey,
+// and the SigningKey is either an *ecdsa.PrivateKey or an
+// ed25519.PrivateKey, depending on the Kind of the Identity.
+//
 // WARNING: This structure should be stored carefully
 // as having access to this data will be enough to
 // impersonate someone on the AirDispatch network.
 type Identity struct {
 	Address       *Address
-	EncryptionKey *rsa.PrivateKey
-	SigningKey    *ecdsa.PrivateKey
+	EncryptionKey interface{}
+	SigningKey    interface{}
 }
 
 // This creates a new random, AirDispatch Identity
 func CreateIdentity() (id *Identity, err error) {
+	return CreateIdentityOfKind(KindRSA)
+}
+
+// This creates a new random, AirDispatch Identity that uses
+// the algorithms specified by kind.
+func CreateIdentityOfKind(kind Kind) (id *Identity, err error) {
 	key := &Identity{}
 
-	// Create Signing Key
-	key.SigningKey, err = ecdsa.GenerateKey(crypto.EllipticCurve, crypto.Random)
+	switch kind {
+	case KindRSA:
+		// Create Signing Key
+		key.SigningKey, err = ecdsa.GenerateKey(crypto.EllipticCurve, crypto.Random)
+		if err != nil {
+			return nil, err
+		}
+
+		key.EncryptionKey, err = rsa.GenerateKey(crypto.Random, 2048)
+		if err != nil {
+			return nil, err
+		}
+	case KindCurve25519:
+		// Create Signing Key
+		_, key.SigningKey, err = ed25519.GenerateKey(crypto.Random)
+		if err != nil {
+			return nil, err
+		}
+
+		key.EncryptionKey, err = ecdh.X25519().GenerateKey(crypto.Random)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("Unknown identity kind.")
+	}
+	key.PopulateAddress()
+
+	return key, err
+}
+
+// Kind returns which algorithms the Identity uses.
+func (a *Identity) Kind() Kind {
+	if _, ok := a.SigningKey.(ed25519.PrivateKey); ok {
+		return KindCurve25519
+	}
+	return KindRSA
+}
+
+// SigningFunc returns the identifier of the signing suite that
+// matches the SigningKey of the Identity.
+func (a *Identity) SigningFunc() ([]byte, error) {
+	return crypto.SigningFuncForKey(a.Address.SigningKey)
+}
+
+// This function signs a series of bytes. The signature includes the
+// public signing key, but not what was signed (see SignDetached).
+func (a *Identity) SignBytes(payload []byte) (*wire.Signature, error) {
+	signingFunc, err := a.SigningFunc()
 	if err != nil {
 		return nil, err
 	}
 
-	key.EncryptionKey, err = rsa.GenerateKey(crypto.Random, 2048)
+	suite, err := crypto.LookupSigningSuite(signingFunc)
 	if err != nil {
 		return nil, err
 	}
-	key.PopulateAddress()
 
-	return key, err
-}
+	r, s, err := suite.Sign(a.SigningKey, payload)
+	if err != nil {
+		return nil, err
+	}
 
-// This function signs a series of bytes
-func (a *Identity) SignBytes(payload []byte) (*wire.Signature, error) {
-	r, s, err := crypto.SignPayload(a.SigningKey, payload)
+	signingKey, err := suite.KeyToBytes(a.Address.SigningKey)
 	if err != nil {
 		return nil, err
 	}
 
 	newSignature := &wire.Signature{
-		R: r.Bytes(),
-		S: s.Bytes(),
+		R:          r,
+		S:          s,
+		SigningKey: signingKey,
 	}
 	return newSignature, nil
 }
@@ -62,8 +135,30 @@ func (a *Identity) SetLocation(newLocation string) {
 
 func (a *Identity) PopulateAddress() {
 	a.Address = &Address{
-		EncryptionKey: &a.EncryptionKey.PublicKey,
-		SigningKey:    &a.SigningKey.PublicKey,
+		EncryptionKey: publicEncryptionKey(a.EncryptionKey),
+		SigningKey:    publicSigningKey(a.SigningKey),
 	}
 	a.Address.generateFingerprint()
 }
+
+// publicEncryptionKey returns the public half of an encryption key.
+func publicEncryptionKey(key interface{}) interface{} {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdh.PrivateKey:
+		return k.PublicKey()
+	}
+	return nil
+}
+
+// publicSigningKey returns the public half of a signing key.
+func publicSigningKey(key interface{}) interface{} {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
+	}
+	return nil
+}
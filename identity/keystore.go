@@ -0,0 +1,192 @@
+package identity
+
+import (
+	"airdispat.ch/crypto"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Encrypted key files begin with KeystorePrefix and a version byte. Version 1
+// files continue with the scrypt parameters (log2 of N, r and p, one byte
+// each) and salt, followed by the Gob-Encoded ADKey sealed with AES-GCM under
+// the passphrase-derived key. Everything before the sealed key is
+// authenticated as additional data.
+//
+// Files that do not begin with KeystorePrefix are legacy, unencrypted
+// Gob-Encoded ADKeys written by SaveKeyToFile.
+var KeystorePrefix = []byte("AD-KEY")
+
+const (
+	KeystoreVersion1 byte = 1
+	keystoreSaltSize      = 16
+	// Bounds the memory and time that a key file can make scrypt use
+	keystoreMaxMemory = 1 << 30
+	keystoreMaxP      = 16
+)
+
+var ErrKeystoreVersion = errors.New("Key file was written with an unknown keystore version.")
+var ErrKeystorePassphrase = errors.New("Key file could not be decrypted with the passphrase provided.")
+var ErrKeystoreEncrypted = errors.New("Key file is encrypted and requires a passphrase.")
+var ErrKeystoreParameters = errors.New("Key file has invalid scrypt parameters.")
+var ErrKeystoreUnencrypted = errors.New("Key file is not encrypted and must be migrated first.")
+
+// This function writes a passphrase-encrypted ADKey to a buffer
+func (a *Identity) EncryptKey(buffer io.Writer, passphrase string) error {
+	if !validScryptParameters(crypto.ScryptLogN, crypto.ScryptR, crypto.ScryptP) {
+		return ErrKeystoreParameters
+	}
+
+	plaintext := &bytes.Buffer{}
+	if _, err := a.GobEncodeKey(plaintext); err != nil {
+		return err
+	}
+
+	salt := make([]byte, keystoreSaltSize)
+	if _, err := io.ReadFull(crypto.Random, salt); err != nil {
+		return err
+	}
+
+	header := bytes.Join([][]byte{
+		KeystorePrefix,
+		{KeystoreVersion1, byte(crypto.ScryptLogN), byte(crypto.ScryptR), byte(crypto.ScryptP)},
+		salt,
+	}, nil)
+
+	key, err := crypto.DeriveKeyFromPassphrase([]byte(passphrase), salt, crypto.ScryptLogN, crypto.ScryptR, crypto.ScryptP)
+	if err != nil {
+		return err
+	}
+
+	ciphertext, err := crypto.EncryptWithKeyGCM(plaintext.Bytes(), key, header)
+	if err != nil {
+		return err
+	}
+
+	_, err = buffer.Write(append(header, ciphertext...))
+	return err
+}
+
+// This function loads a passphrase-encrypted ADKey from a buffer. Legacy,
+// unencrypted keys return ErrKeystoreUnencrypted (see MigrateKeyFile).
+func DecryptKey(buffer io.Reader, passphrase string) (*Identity, error) {
+	data, err := ioutil.ReadAll(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isEncryptedKey(data) {
+		return nil, ErrKeystoreUnencrypted
+	}
+	rest := data[len(KeystorePrefix):]
+
+	if len(rest) < 1 || rest[0] != KeystoreVersion1 {
+		return nil, ErrKeystoreVersion
+	}
+	rest = rest[1:]
+
+	if len(rest) < 3+keystoreSaltSize {
+		return nil, ErrKeystorePassphrase
+	}
+	logN, r, p := int(rest[0]), int(rest[1]), int(rest[2])
+	if !validScryptParameters(logN, r, p) {
+		return nil, ErrKeystoreParameters
+	}
+	rest = rest[3:]
+
+	salt, ciphertext := rest[:keystoreSaltSize], rest[keystoreSaltSize:]
+	header := data[:len(data)-len(ciphertext)]
+
+	key, err := crypto.DeriveKeyFromPassphrase([]byte(passphrase), salt, logN, r, p)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := crypto.DecryptWithKeyGCM(ciphertext, key, header)
+	if err != nil {
+		return nil, ErrKeystorePassphrase
+	}
+
+	return GobDecodeKey(bytes.NewReader(plaintext))
+}
+
+// Checks that the scrypt parameters fit in a byte each and don't use more
+// than keystoreMaxMemory
+func validScryptParameters(logN int, r int, p int) bool {
+	if logN < 1 || logN > 30 || r < 1 || r > 255 || p < 1 || p > keystoreMaxP {
+		return false
+	}
+	return 128*r<<uint(logN) <= keystoreMaxMemory
+}
+
+func isEncryptedKey(data []byte) bool {
+	return bytes.HasPrefix(data, KeystorePrefix)
+}
+
+// This function Loads a passphrase-encrypted Airdispatch Key from a File.
+// Legacy, unencrypted key files are refused with ErrKeystoreUnencrypted until
+// they have been migrated with MigrateKeyFile.
+func LoadKeyFromFileWithPassphrase(filename string, passphrase string) (*Identity, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return DecryptKey(file, passphrase)
+}
+
+// This function Saves a passphrase-encrypted Airdispatch Key to a File that
+// is only readable by its owner.
+func (a *Identity) SaveKeyToFileWithPassphrase(filename string, passphrase string) error {
+	buffer := &bytes.Buffer{}
+	if err := a.EncryptKey(buffer, passphrase); err != nil {
+		return err
+	}
+
+	return writeKeyFile(filename, buffer.Bytes())
+}
+
+// MigrateKeyFile rewrites a legacy, unencrypted key file in the encrypted
+// keystore format. Files that are already encrypted are left untouched.
+func MigrateKeyFile(filename string, passphrase string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	if isEncryptedKey(data) {
+		return nil
+	}
+
+	id, err := GobDecodeKey(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	return id.SaveKeyToFileWithPassphrase(filename, passphrase)
+}
+
+// writeKeyFile atomically replaces filename with data, creating it with
+// permissions that only allow the owner to read it.
+func writeKeyFile(filename string, data []byte) error {
+	temp := filename + ".tmp"
+
+	file, err := os.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	return os.Rename(temp, filename)
+}
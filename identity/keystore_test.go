@@ -0,0 +1,113 @@
+package identity
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"airdispat.ch/crypto"
+)
+
+func TestKeystore(t *testing.T) {
+	id, err := CreateIdentityOfKind(KindCurve25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "key")
+	if err := id.SaveKeyToFileWithPassphrase(filename, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Error("Key file has permissions", info.Mode().Perm())
+	}
+
+	if _, err := LoadKeyFromFileWithPassphrase(filename, "wrong horse"); err != ErrKeystorePassphrase {
+		t.Error("Expected passphrase error, got", err)
+	}
+
+	if _, err := LoadKeyFromFile(filename); err != ErrKeystoreEncrypted {
+		t.Error("Expected encrypted keystore error, got", err)
+	}
+
+	loaded, err := LoadKeyFromFileWithPassphrase(filename, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Address.String() != id.Address.String() {
+		t.Error("Loaded key has a different address.")
+	}
+}
+
+func TestKeystoreMigration(t *testing.T) {
+	id, err := CreateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "key")
+	if err := id.SaveKeyToFile(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadKeyFromFileWithPassphrase(filename, "passphrase"); err != ErrKeystoreUnencrypted {
+		t.Error("Expected unencrypted keystore error, got", err)
+	}
+
+	if err := MigrateKeyFile(filename, "passphrase"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadKeyFromFile(filename); err != ErrKeystoreEncrypted {
+		t.Error("Expected migrated key file to be encrypted, got", err)
+	}
+
+	loaded, err := LoadKeyFromFileWithPassphrase(filename, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Address.String() != id.Address.String() {
+		t.Error("Migrated key has a different address.")
+	}
+}
+
+func TestKeystoreParameters(t *testing.T) {
+	id, err := CreateIdentityOfKind(KindCurve25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files keep the parameters that they were written with.
+	defer func(logN int) { crypto.ScryptLogN = logN }(crypto.ScryptLogN)
+	crypto.ScryptLogN = 10
+
+	buffer := &bytes.Buffer{}
+	if err := id.EncryptKey(buffer, "passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	crypto.ScryptLogN = 15
+
+	if _, err := DecryptKey(bytes.NewReader(data), "passphrase"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The header is authenticated along with the key.
+	tampered := append([]byte{}, data...)
+	tampered[len(KeystorePrefix)+4] ^= 1
+	if _, err := DecryptKey(bytes.NewReader(tampered), "passphrase"); err != ErrKeystorePassphrase {
+		t.Error("Expected a tampered salt to fail, got", err)
+	}
+
+	tampered = append([]byte{}, data...)
+	tampered[len(KeystorePrefix)+1] = 40
+	if _, err := DecryptKey(bytes.NewReader(tampered), "passphrase"); err != ErrKeystoreParameters {
+		t.Error("Expected excessive parameters to be rejected, got", err)
+	}
+}
@@ -0,0 +1,69 @@
+package identity
+
+import (
+	"airdispat.ch/crypto"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// How long certificates created by TLSCertificate are valid for. Clients
+// that pin the certificate to an Address only check the key, not the dates.
+var TLSCertificateLifetime = 365 * 24 * time.Hour
+
+// TLSCertificate creates a self-signed certificate for the signing key of
+// the Identity. Clients can pin it to the Identity with
+// Address.VerifyTLSCertificate rather than trusting a certificate authority.
+func (a *Identity) TLSCertificate() (tls.Certificate, error) {
+	serial, err := rand.Int(crypto.Random, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: a.Address.String(),
+		},
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(TLSCertificateLifetime),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(crypto.Random, template, template, a.Address.SigningKey, a.SigningKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  a.SigningKey,
+	}, nil
+}
+
+// VerifyTLSCertificate checks that the leaf of a certificate chain (as passed
+// to tls.Config.VerifyPeerCertificate) was made with the signing key of the
+// Address.
+func (a *Address) VerifyTLSCertificate(rawCerts [][]byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New("No TLS certificate was presented.")
+	}
+
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+
+	key := crypto.SigningKeyToBytes(cert.PublicKey)
+	if key == nil || !bytes.Equal(crypto.BytesToAddress(key), a.Fingerprint) {
+		return errors.New("TLS certificate does not belong to the address.")
+	}
+	return nil
+}
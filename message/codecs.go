@@ -0,0 +1,279 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"airdispat.ch/wire"
+	"code.google.com/p/goprotobuf/proto"
+)
+
+// Well-known component names, and the codecs registered for them.
+const (
+	SubjectComponent   = "ch.airdispat.mail.subject"   // TextCodec
+	BodyComponent      = "ch.airdispat.mail.body"      // TextCodec
+	SentComponent      = "ch.airdispat.mail.sent"      // TimeCodec
+	PriorityComponent  = "ch.airdispat.mail.priority"  // Int64Codec
+	MetadataComponent  = "ch.airdispat.mail.metadata"  // JSONCodec
+	ForwardedComponent = "ch.airdispat.mail.forwarded" // MailCodec
+)
+
+// ErrMissingComponent is the Err of a ComponentError for a component that
+// the mail doesn't have.
+var ErrMissingComponent = errors.New("Mail doesn't have that component.")
+
+// ComponentError is returned when a component is missing, or can't be
+// decoded by its codec.
+type ComponentError struct {
+	Name string
+	Err  error
+}
+
+func (e *ComponentError) Error() string {
+	return "Component " + e.Name + " is invalid: " + e.Err.Error()
+}
+
+// ComponentCodec checks that the data of a component is well formed.
+type ComponentCodec interface {
+	Validate(data []byte) error
+}
+
+// The codecs for the types of data that components commonly hold
+var (
+	// UTF-8 text.
+	TextCodec ComponentCodec = textCodec{}
+	// A signed 64 bit integer, written in decimal.
+	Int64Codec ComponentCodec = int64Codec{}
+	// A time, written in RFC 3339 format with nanoseconds.
+	TimeCodec ComponentCodec = timeCodec{}
+	// A JSON document.
+	JSONCodec ComponentCodec = jsonCodec{}
+	// A signed Mail, such as one that is being forwarded.
+	MailCodec ComponentCodec = mailCodec{}
+)
+
+var (
+	codecLock sync.RWMutex
+	codecs    = map[string]ComponentCodec{
+		SubjectComponent:   TextCodec,
+		BodyComponent:      TextCodec,
+		SentComponent:      TimeCodec,
+		PriorityComponent:  Int64Codec,
+		MetadataComponent:  JSONCodec,
+		ForwardedComponent: MailCodec,
+	}
+)
+
+// RegisterComponentCodec makes ComponentList.Validate check components with
+// a name using codec. Applications should register the names they define,
+// such as "ch.airdispat.notes.title".
+func RegisterComponentCodec(name string, codec ComponentCodec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	codecs[name] = codec
+}
+
+// LookupComponentCodec returns the codec registered for a component name, or
+// nil if there isn't one.
+func LookupComponentCodec(name string) ComponentCodec {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	return codecs[name]
+}
+
+// Validate checks every component that has a registered codec, and returns a
+// *ComponentError for the first one that isn't well formed.
+func (c ComponentList) Validate() error {
+	for _, v := range c {
+		codec := LookupComponentCodec(v.Name)
+		if codec == nil {
+			continue
+		}
+		if err := codec.Validate(v.Data); err != nil {
+			return &ComponentError{v.Name, err}
+		}
+	}
+	return nil
+}
+
+// Returns the data of the first component with a name
+func (c ComponentList) find(name string) ([]byte, error) {
+	for _, v := range c {
+		if v.Name == name {
+			return v.Data, nil
+		}
+	}
+	return nil, &ComponentError{name, ErrMissingComponent}
+}
+
+type textCodec struct{}
+
+func (textCodec) Validate(data []byte) error {
+	if !utf8.Valid(data) {
+		return errors.New("Text is not valid UTF-8.")
+	}
+	return nil
+}
+
+// GetTextComponent will return the first component with a name as a string,
+// once it has been checked to be UTF-8.
+func (c ComponentList) GetTextComponent(name string) (string, error) {
+	data, err := c.find(name)
+	if err != nil {
+		return "", err
+	} else if err := TextCodec.Validate(data); err != nil {
+		return "", &ComponentError{name, err}
+	}
+	return string(data), nil
+}
+
+type int64Codec struct{}
+
+func (int64Codec) Validate(data []byte) error {
+	_, err := strconv.ParseInt(string(data), 10, 64)
+	return err
+}
+
+// CreateInt64Component will return a new component given a name and an
+// integer.
+func CreateInt64Component(name string, data int64) Component {
+	return CreateStringComponent(name, strconv.FormatInt(data, 10))
+}
+
+// GetInt64Component will return the first component with a name as an
+// integer.
+func (c ComponentList) GetInt64Component(name string) (int64, error) {
+	data, err := c.find(name)
+	if err != nil {
+		return 0, err
+	}
+
+	i, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return 0, &ComponentError{name, err}
+	}
+	return i, nil
+}
+
+type timeCodec struct{}
+
+func (timeCodec) Validate(data []byte) error {
+	_, err := time.Parse(time.RFC3339Nano, string(data))
+	return err
+}
+
+// CreateTimeComponent will return a new component given a name and a time.
+func CreateTimeComponent(name string, data time.Time) Component {
+	return CreateStringComponent(name, data.Format(time.RFC3339Nano))
+}
+
+// GetTimeComponent will return the first component with a name as a time.
+func (c ComponentList) GetTimeComponent(name string) (time.Time, error) {
+	data, err := c.find(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, string(data))
+	if err != nil {
+		return time.Time{}, &ComponentError{name, err}
+	}
+	return t, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Validate(data []byte) error {
+	if !json.Valid(data) {
+		return errors.New("Data is not a valid JSON document.")
+	}
+	return nil
+}
+
+// CreateJSONComponent will return a new component given a name and a value
+// to marshal to JSON.
+func CreateJSONComponent(name string, v interface{}) (Component, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return Component{}, err
+	}
+	return CreateComponent(name, data), nil
+}
+
+// GetJSONComponent will unmarshal the first component with a name into v.
+func (c ComponentList) GetJSONComponent(name string, v interface{}) error {
+	data, err := c.find(name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return &ComponentError{name, err}
+	}
+	return nil
+}
+
+type mailCodec struct{}
+
+func (mailCodec) Validate(data []byte) error {
+	_, err := decodeMailComponent(data)
+	return err
+}
+
+// Unmarshals a SignedMessage and returns the Mail inside of it once its
+// signature has been verified
+func decodeMailComponent(data []byte) (*Mail, error) {
+	unmarsh := &wire.SignedMessage{}
+	if err := proto.Unmarshal(data, unmarsh); err != nil {
+		return nil, err
+	}
+
+	signed := &SignedMessage{
+		Data:        unmarsh.GetData(),
+		Signature:   unmarsh.GetSignature(),
+		SigningFunc: unmarsh.GetSigningFunc(),
+	}
+	if !signed.Verify() {
+		return nil, errors.New("Unable to verify signed mail.")
+	}
+
+	by, typ, h, err := signed.ReconstructMessage()
+	if err != nil {
+		return nil, err
+	} else if typ != wire.MailCode {
+		return nil, errors.New("Signed message is not a Mail.")
+	}
+	return CreateMailFromBytes(by, h)
+}
+
+// CreateMailComponent will return a new component given a name and a signed
+// Mail, so that the mail can be forwarded with its original signature.
+func CreateMailComponent(name string, mail *SignedMessage) (Component, error) {
+	data, err := proto.Marshal(&wire.SignedMessage{
+		Data:        mail.Data,
+		Signature:   mail.Signature,
+		SigningFunc: mail.SigningFunc,
+	})
+	if err != nil {
+		return Component{}, err
+	}
+	return CreateComponent(name, data), nil
+}
+
+// GetMailComponent will return the Mail in the first component with a name,
+// once the signature of its author has been verified.
+func (c ComponentList) GetMailComponent(name string) (*Mail, error) {
+	data, err := c.find(name)
+	if err != nil {
+		return nil, err
+	}
+
+	mail, err := decodeMailComponent(data)
+	if err != nil {
+		return nil, &ComponentError{name, err}
+	}
+	return mail, nil
+}
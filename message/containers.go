@@ -1,6 +1,7 @@
 package message
 
 import (
+	"encoding/binary"
 	"net"
 	"time"
 
@@ -34,6 +35,23 @@ func SignAndSend(m Message, from *identity.Identity, to *identity.Address) error
 	return encrypted.Send()
 }
 
+// SignAndSendToMany will sign and encrypt a message once for every address in
+// to, then send one copy to each server hosting them. It returns the result of
+// the delivery to each address, in the same order as to.
+func SignAndSendToMany(m Message, from *identity.Identity, to ...*identity.Address) ([]Delivery, error) {
+	signed, err := SignMessage(m, from)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := signed.EncryptWithKeys(to...)
+	if err != nil {
+		return nil, err
+	}
+
+	return encrypted.deliver(to), nil
+}
+
 // SignAndSendToConnection performs exactly the same function as SignAndSend, but
 // it can utilize an already open connection. Useful for responding to messages.
 func SignAndSendToConnection(m Message, from *identity.Identity, to *identity.Address, conn net.Conn) error {
@@ -60,6 +78,8 @@ type Header struct {
 	// Location Options
 	EncryptionKey []byte
 	Alias         string
+	// A random number that makes every message unique
+	Nonce uint64
 }
 
 // CreateHeader will return a basic header for a from address and a to address.
@@ -69,16 +89,26 @@ func CreateHeader(from *identity.Address, to ...*identity.Address) Header {
 		To:        to,
 		Timestamp: time.Now().Unix(),
 		Alias:     from.Alias,
+		Nonce:     randomNonce(),
 	}
 }
 
+// randomNonce returns a random number for a header. If the random source
+// fails, the nonce is left as zero and messages are told apart by their
+// other contents.
+func randomNonce() uint64 {
+	var nonce uint64
+	binary.Read(crypto.Random, binary.BigEndian, &nonce)
+	return nonce
+}
+
 // createHeaderFromWire will unmarshal a header
 func createHeaderFromWire(w *wire.Header) (Header, error) {
 	from := identity.CreateAddressFromBytes(w.GetFromAddr())
 
 	if len(w.GetEncryptionKey()) != 0 {
 		var err error
-		from.EncryptionKey, err = crypto.BytesToRSA(w.GetEncryptionKey())
+		from.EncryptionKey, err = crypto.BytesToEncryptionKey(w.GetEncryptionKey())
 		if err != nil {
 			return Header{}, err
 		}
@@ -99,6 +129,7 @@ func createHeaderFromWire(w *wire.Header) (Header, error) {
 		Timestamp:     int64(w.GetTimestamp()),
 		EncryptionKey: w.GetEncryptionKey(),
 		Alias:         w.GetAlias(),
+		Nonce:         w.GetNonce(),
 	}, nil
 }
 
@@ -117,5 +148,6 @@ func (h Header) toWire() *wire.Header {
 		Timestamp:     &time,
 		EncryptionKey: h.EncryptionKey,
 		Alias:         &h.Alias,
+		Nonce:         &h.Nonce,
 	}
 }
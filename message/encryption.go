@@ -3,6 +3,7 @@ package message
 import (
 	"bytes"
 	"errors"
+	"sync"
 
 	"airdispat.ch/crypto"
 	"airdispat.ch/identity"
@@ -63,43 +64,116 @@ func (e *EncryptedMessage) AddRecipient(addr *identity.Address) error {
 		e.Header = make(map[string]EncryptionHeader)
 	}
 
-	key, err := crypto.EncryptAESKey(e.unencryptedKey, addr.EncryptionKey)
+	// Every built-in suite for a key encrypts its data with AES-GCM, so the
+	// recipients of one message may use different kinds of keys.
+	encryptionType, err := crypto.EncryptionTypeForKey(addr.EncryptionKey)
+	if err != nil {
+		return err
+	}
+
+	suite, err := crypto.LookupEncryptionSuite(encryptionType)
+	if err != nil {
+		return err
+	}
+
+	key, err := suite.EncryptKey(e.unencryptedKey, addr.EncryptionKey)
 	if err != nil {
 		return err
 	}
 
 	e.Header[addr.String()] = EncryptionHeader{
 		EncryptionKey:  key,
-		EncryptionType: crypto.EncryptionRSA,
+		EncryptionType: encryptionType,
 		To:             addr,
 	}
 	return nil
 }
 
+// Delivery is the result of sending a message to one recipient.
+type Delivery struct {
+	To    *identity.Address
+	Error error
+}
+
 // Send will connect to each recipient's server and send the message to them.
+// Every server is tried, and the first error is returned.
 func (e *EncryptedMessage) Send() error {
 	if e.Header == nil || len(e.Header) == 0 {
 		return errors.New("Can't send message without a receipient.")
 	}
 
-	for _, v := range e.Header {
-		if v.To.Location == "" {
-			return errors.New("Cannot send to address without location.")
+	for _, v := range e.Deliver() {
+		if v.Error != nil {
+			return v.Error
 		}
+	}
+	return nil
+}
 
-		conn, err := ConnectToServer(v.To.Location)
-		if err != nil {
-			return err
-		}
-		defer conn.Close()
+// Deliver will send the message to every recipient and return the result for
+// each of them. See SignAndSendToMany.
+func (e *EncryptedMessage) Deliver() []Delivery {
+	recipients := make([]*identity.Address, 0, len(e.Header))
+	for _, v := range e.Header {
+		recipients = append(recipients, v.To)
+	}
+	return e.deliver(recipients)
+}
 
-		err = e.SendMessageToConnection(conn)
-		if err != nil {
-			return err
+// Sends one copy of the message to each server hosting the recipients, at
+// the same time. Each copy only carries the key information for the
+// recipients on that server. The recipients aren't the servers, so
+// connections are only pinned to servers that the transport knows (see
+// TLSTransport.Servers).
+func (e *EncryptedMessage) deliver(recipients []*identity.Address) []Delivery {
+	results := make([]Delivery, len(recipients))
+	servers := make(map[string][]int)
+
+	for i, v := range recipients {
+		results[i].To = v
+		if _, ok := e.Header[v.String()]; !ok {
+			results[i].Error = errors.New("Message is not encrypted for recipient.")
+		} else if v.Location == "" {
+			results[i].Error = errors.New("Cannot send to address without location.")
+		} else {
+			servers[v.Location] = append(servers[v.Location], i)
 		}
 	}
 
-	return nil
+	var wg sync.WaitGroup
+	for location, group := range servers {
+		wg.Add(1)
+		go func(location string, group []int) {
+			defer wg.Done()
+
+			serverCopy := &EncryptedMessage{
+				Data:   e.Data,
+				Header: make(map[string]EncryptionHeader),
+			}
+			for _, i := range group {
+				addr := recipients[i].String()
+				serverCopy.Header[addr] = e.Header[addr]
+			}
+
+			err := serverCopy.sendToServer(location)
+			for _, i := range group {
+				results[i].Error = err
+			}
+		}(location, group)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func (e *EncryptedMessage) sendToServer(location string) error {
+	conn, err := ConnectToServer(location)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return e.SendMessageToConnection(conn)
 }
 
 // Decrypt will use an *identity.Identity to decrypt the EncryptedMessage
@@ -118,8 +192,20 @@ func (e *EncryptedMessage) Decrypt(id *identity.Identity) (*SignedMessage, error
 		return e.UnencryptedMessage()
 	}
 
+	// Messages without an encryption type predate the suite registry and
+	// always used RSA with AES-CFB.
+	encryptionType := data.EncryptionType
+	if len(encryptionType) == 0 {
+		encryptionType = crypto.EncryptionRSA
+	}
+
+	suite, err := crypto.LookupEncryptionSuite(encryptionType)
+	if err != nil {
+		return nil, err
+	}
+
 	// Decrypt the data from the cipher.
-	p, err := crypto.HybridDecryption(id.EncryptionKey, data.EncryptionKey, e.Data)
+	p, err := suite.DecryptData(id.EncryptionKey, data.EncryptionKey, e.Data)
 	if err != nil {
 		return nil, err
 	}
@@ -168,10 +254,18 @@ func (e *EncryptedMessage) UnencryptedMessage() (*SignedMessage, error) {
 // verifying the signed message, and reconstructing the signed message
 // (optionally with timestamp support).
 func (e *EncryptedMessage) Reconstruct(receiver *identity.Identity, ts bool) ([]byte, string, Header, error) {
+	return e.ReconstructAcceptingSuccessions(receiver, ts)
+}
+
+// ReconstructAcceptingSuccessions will do the same thing as Reconstruct, but
+// it will also accept messages signed by the successors of the sender (see
+// SignedMessage.AcceptSuccession).
+func (e *EncryptedMessage) ReconstructAcceptingSuccessions(receiver *identity.Identity, ts bool, succ ...*Succession) ([]byte, string, Header, error) {
 	receivedSign, err := e.Decrypt(receiver)
 	if err != nil {
 		return nil, "", Header{}, err
 	}
+	receivedSign.AcceptSuccession(succ...)
 
 	if !receivedSign.Verify() {
 		return nil, "", Header{}, errors.New("Unable to Verify Message")
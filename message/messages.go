@@ -10,13 +10,18 @@ import (
 
 // Mail is the basic form of a user-visible AirDispatch message.
 //
-// It contains a list of components that effectively serve as a key-value
-// dictionary of string -> []byte. Most of the time, the []byte can be
-// interpreted as a UTF-8 string.
+// It contains an ordered list of components that effectively serve as a
+// key-value dictionary of string -> []byte, where a key may have several
+// values. Most of the time, the []byte can be interpreted as a UTF-8 string.
 type Mail struct {
 	h          Header
 	Name       string
 	Components ComponentList
+
+	// Where the mail belongs in a conversation (see CreateReply)
+	InReplyTo  *Reference
+	Thread     *Reference
+	References []Reference
 }
 
 // CreateMail will return a new Mail object with the correct header, ready for
@@ -42,15 +47,18 @@ func CreateMailFromBytes(by []byte, h Header) (*Mail, error) {
 	}
 
 	c := unmarsh.GetComponents()
-	comp := make(map[string]Component)
-	for _, v := range c {
-		comp[v.GetType()] = CreateComponent(v.GetType(), v.GetData())
+	comp := make(ComponentList, len(c))
+	for i, v := range c {
+		comp[i] = CreateComponent(v.GetType(), v.GetData())
 	}
 
 	return &Mail{
 		h:          h,
 		Name:       unmarsh.GetName(),
 		Components: comp,
+		InReplyTo:  createReferenceFromWire(unmarsh.GetInReplyTo()),
+		Thread:     createReferenceFromWire(unmarsh.GetThread()),
+		References: createReferencesFromWire(unmarsh.GetReferences()),
 	}, nil
 }
 
@@ -59,6 +67,13 @@ func (m *Mail) ToBytes() []byte {
 	wireFormat := &wire.Mail{
 		Components: m.Components.toWire(),
 		Name:       &m.Name,
+		InReplyTo:  m.InReplyTo.toWire(),
+		Thread:     m.Thread.toWire(),
+	}
+	for _, v := range m.References {
+		if ref := v.toWire(); ref != nil {
+			wireFormat.References = append(wireFormat.References, ref)
+		}
 	}
 	by, err := proto.Marshal(wireFormat)
 	if err != nil {
@@ -79,39 +94,93 @@ func (m *Mail) Header() Header {
 	return m.h
 }
 
-// ComponentList maps keys (strings) to components.
-type ComponentList map[string]Component
+// ComponentList is an ordered list of components. Several components may
+// have the same name.
+//
+// Components are sent in the order of the list, so the same list always
+// marshals to the same bytes. As with any slice, components added to a copy of
+// the list aren't added to the original.
+type ComponentList []Component
 
 // toWire will marshal a ComponentList to the wire format.
 func (c ComponentList) toWire() []*wire.Mail_Component {
 	output := make([]*wire.Mail_Component, len(c))
-	i := 0
-	for _, v := range c {
+	for i, v := range c {
 		newName := v.Name
 		output[i] = &wire.Mail_Component{
 			Type: &newName,
 			Data: v.Data,
 		}
-		i++
 	}
 	return output
 }
 
-// AddComponent will add a new component object into the list.
-func (c ComponentList) AddComponent(comp Component) {
-	c[comp.Name] = comp
+// AddComponent will replace every component with the same name as comp. It
+// takes the place of the first of them, or is added to the end of the list.
+func (c *ComponentList) AddComponent(comp Component) {
+	set := false
+	out := make(ComponentList, 0, len(*c)+1)
+	for _, v := range *c {
+		if v.Name != comp.Name {
+			out = append(out, v)
+		} else if !set {
+			out = append(out, comp)
+			set = true
+		}
+	}
+	if !set {
+		out = append(out, comp)
+	}
+	*c = out
+}
+
+// AppendComponent will add a new component object to the end of the list,
+// after any others with the same name.
+func (c *ComponentList) AppendComponent(comp Component) {
+	*c = append(*c, comp)
+}
+
+// RemoveComponent will remove every component with a name.
+func (c *ComponentList) RemoveComponent(name string) {
+	out := make(ComponentList, 0, len(*c))
+	for _, v := range *c {
+		if v.Name != name {
+			out = append(out, v)
+		}
+	}
+	*c = out
 }
 
 // HasComponent will return whether a Mail object contains a component.
 func (c ComponentList) HasComponent(name string) bool {
-	_, ok := c[name]
-	return ok
+	for _, v := range c {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
-// GetComponent will return the []byte associated with a component name.
+// GetComponent will return the []byte associated with the first component
+// with a name.
 func (c ComponentList) GetComponent(name string) []byte {
-	b, _ := c[name]
-	return b.Data
+	for _, v := range c {
+		if v.Name == name {
+			return v.Data
+		}
+	}
+	return nil
+}
+
+// GetComponents will return every component with a name, in order.
+func (c ComponentList) GetComponents(name string) []Component {
+	var out []Component
+	for _, v := range c {
+		if v.Name == name {
+			out = append(out, v)
+		}
+	}
+	return out
 }
 
 // GetStringComponent will return the []byte associated with a component name
@@ -120,22 +189,17 @@ func (c ComponentList) GetStringComponent(name string) string {
 	return string(c.GetComponent(name))
 }
 
-// ToArray will return the ComponentList as an array of components.
+// ToArray will return a copy of the ComponentList as an array of components.
 func (c ComponentList) ToArray() []Component {
-	var out = make([]Component, len(c))
-	var i int
-	for _, v := range c {
-		out[i] = v
-		i++
-	}
-	return out
+	return append([]Component{}, c...)
 }
 
 // Component is a basic unit in an AirDispatch message. It has a name, generally
 // a string akin to Apple's bundle ids.
 //
 // E.G. if I am creating an AirDispatch application at http://airdispat.ch/notes
-// I may have a data type called "ch.airdispat.notes.title".
+// I may have a data type called "ch.airdispat.notes.title", and register a
+// ComponentCodec for it (see RegisterComponentCodec).
 type Component struct {
 	Name string
 	Data []byte
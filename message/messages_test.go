@@ -0,0 +1,118 @@
+package message
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"airdispat.ch/identity"
+)
+
+func TestComponentList(t *testing.T) {
+	from := identity.CreateAddressFromBytes([]byte("alice"))
+
+	create := func() *Mail {
+		mail := CreateMail(from, time.Unix(0, 0), "list")
+		for _, v := range []string{"z", "a", "m", "a", "b"} {
+			mail.Components.AppendComponent(CreateStringComponent("ch.airdispat.test."+v, v))
+		}
+		return mail
+	}
+
+	first, second := create(), create()
+	if !bytes.Equal(first.ToBytes(), second.ToBytes()) {
+		t.Error("Expected the same components to marshal to the same bytes.")
+	}
+
+	received, err := CreateMailFromBytes(first.ToBytes(), first.Header())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received.Components) != 5 || received.Components[0].Name != "ch.airdispat.test.z" {
+		t.Error("Expected components in order, got", received.Components)
+	}
+	if a := received.Components.GetComponents("ch.airdispat.test.a"); len(a) != 2 {
+		t.Error("Expected both components named a, got", a)
+	}
+
+	received.Components.AddComponent(CreateStringComponent("ch.airdispat.test.a", "set"))
+	if len(received.Components) != 4 || received.Components[1].String() != "set" {
+		t.Error("Expected AddComponent to replace both components, got", received.Components)
+	}
+
+	// Adding a component again updates it.
+	received.Components.AddComponent(CreateStringComponent("ch.airdispat.test.b", "updated"))
+	if received.Components.GetStringComponent("ch.airdispat.test.b") != "updated" || len(received.Components) != 4 {
+		t.Error("Expected AddComponent to update the component, got", received.Components)
+	}
+
+	received.Components.RemoveComponent("ch.airdispat.test.a")
+	if received.Components.HasComponent("ch.airdispat.test.a") || len(received.Components) != 3 {
+		t.Error("Expected component to be removed, got", received.Components)
+	}
+}
+
+func TestComponentCodecs(t *testing.T) {
+	sender, err := identity.CreateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := CreateMail(sender.Address, time.Now(), "original")
+	original.Components.AddComponent(CreateStringComponent(BodyComponent, "hello world"))
+	signed, err := SignMessage(original, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent := time.Date(2014, 6, 1, 12, 0, 0, 5, time.UTC)
+	forwarded, err := CreateMailComponent(ForwardedComponent, signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := CreateJSONComponent(MetadataComponent, map[string]int{"read": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c ComponentList
+	c.AddComponent(CreateTimeComponent(SentComponent, sent))
+	c.AddComponent(CreateInt64Component(PriorityComponent, -3))
+	c.AddComponent(metadata)
+	c.AddComponent(forwarded)
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := c.GetTimeComponent(SentComponent); err != nil || !got.Equal(sent) {
+		t.Error("Unexpected time component.", got, err)
+	}
+	if got, err := c.GetInt64Component(PriorityComponent); err != nil || got != -3 {
+		t.Error("Unexpected int64 component.", got, err)
+	}
+	var read map[string]int
+	if err := c.GetJSONComponent(MetadataComponent, &read); err != nil || read["read"] != 1 {
+		t.Error("Unexpected JSON component.", read, err)
+	}
+	mail, err := c.GetMailComponent(ForwardedComponent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := mail.Components.GetTextComponent(BodyComponent); err != nil || body != "hello world" {
+		t.Error("Unexpected forwarded mail.", body, err)
+	}
+
+	if _, err := c.GetTextComponent(SubjectComponent); err.(*ComponentError).Err != ErrMissingComponent {
+		t.Error("Expected missing component, got", err)
+	}
+
+	c.AddComponent(CreateComponent(SubjectComponent, []byte{0xff, 0xfe}))
+	if err, ok := c.Validate().(*ComponentError); !ok || err.Name != SubjectComponent {
+		t.Error("Expected invalid UTF-8 to fail validation, got", err)
+	}
+
+	c.AddComponent(CreateStringComponent(PriorityComponent, "high"))
+	if _, err := c.GetInt64Component(PriorityComponent); err == nil {
+		t.Error("Expected an invalid integer to be rejected.")
+	}
+}
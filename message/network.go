@@ -7,20 +7,16 @@ import (
 	"airdispat.ch/wire"
 )
 
-// ConnectToServer is a convenience method that attempts to dial a tcp connection
-// to a server specified by a string.
+// ConnectToServer is a convenience method that attempts to dial a connection
+// to a server specified by a string using the DefaultTransport.
 func ConnectToServer(remote string) (net.Conn, error) {
-	address, err := net.ResolveTCPAddr("tcp", remote)
-	if err != nil {
-		return nil, err
-	}
+	return DefaultTransport.Dial(remote, nil)
+}
 
-	// Connect to the Remote Mail Server
-	conn, err := net.DialTCP("tcp", nil, address)
-	if err != nil {
-		return nil, err
-	}
-	return conn, nil
+// ConnectToAddress dials the location of a server's address using the
+// DefaultTransport, allowing the transport to authenticate the server.
+func ConnectToAddress(server *identity.Address) (net.Conn, error) {
+	return DefaultTransport.Dial(server.Location, server)
 }
 
 // SendMessageAndReceive does exactly what you think:
@@ -49,7 +45,7 @@ func sendMessageAndReceive(m Message, sender *identity.Identity, addr *identity.
 		return nil, "", Header{}, err
 	}
 
-	conn, err := ConnectToServer(addr.Location)
+	conn, err := ConnectToAddress(addr)
 	if err != nil {
 		return nil, "", Header{}, err
 	}
@@ -71,7 +67,14 @@ func sendMessageAndReceive(m Message, sender *identity.Identity, addr *identity.
 // ReadMessageFromConnection will return a read EncryptedMessage off a specified
 // net.Conn.
 func ReadMessageFromConnection(conn net.Conn) (*EncryptedMessage, error) {
-	totalBytes, err := wire.ReadBytes(conn)
+	return ReadMessageFromFrameReader(wire.NewFrameReader(conn))
+}
+
+// ReadMessageFromFrameReader will return the next EncryptedMessage read from a
+// wire.FrameReader. This allows reading several messages from one connection
+// with the same size limits and deadlines.
+func ReadMessageFromFrameReader(r *wire.FrameReader) (*EncryptedMessage, error) {
+	totalBytes, err := r.ReadFrame()
 	if err != nil {
 		return nil, err
 	}
@@ -86,11 +89,16 @@ func ReadMessageFromConnection(conn net.Conn) (*EncryptedMessage, error) {
 
 // SendMessageToConnection will send an encryptedMessage to a connection.
 func (e *EncryptedMessage) SendMessageToConnection(conn net.Conn) error {
+	return e.SendMessageToFrameWriter(wire.NewFrameWriter(conn))
+}
+
+// SendMessageToFrameWriter will send an encryptedMessage as a single frame on a
+// wire.FrameWriter.
+func (e *EncryptedMessage) SendMessageToFrameWriter(w *wire.FrameWriter) error {
 	bytes, err := e.ToBytes()
 	if err != nil {
 		return err
 	}
 
-	conn.Write(wire.PrefixBytes(bytes))
-	return nil
+	return w.WriteFrame(bytes)
 }
@@ -0,0 +1,136 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+
+	"airdispat.ch/identity"
+)
+
+// SignaturePolicy says which addresses must have signed a message for it to
+// be accepted, such as "2 of these 3 addresses" or "every recipient".
+type SignaturePolicy struct {
+	// The addresses that may sign the message.
+	Members []*identity.Address
+	// Also treat every address in Header.To as a member.
+	IncludeRecipients bool
+	// How many members must have signed. If zero, all of them must have. It
+	// can't be negative or more than the number of members.
+	Threshold int
+}
+
+// CreateThresholdPolicy returns a policy that requires threshold of members
+// to sign a message.
+func CreateThresholdPolicy(threshold int, members ...*identity.Address) *SignaturePolicy {
+	return &SignaturePolicy{
+		Members:   members,
+		Threshold: threshold,
+	}
+}
+
+// CreateRecipientsPolicy returns a policy that requires every address in
+// Header.To to co-sign a message.
+func CreateRecipientsPolicy() *SignaturePolicy {
+	return &SignaturePolicy{
+		IncludeRecipients: true,
+	}
+}
+
+// PolicyError is returned by ReconstructMessageWithPolicy when not enough
+// members of the policy signed the message.
+type PolicyError struct {
+	Signed   []*identity.Address
+	Required int
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("Message doesn't satisfy the signature policy. Signed: %d, Required: %d", len(e.Signed), e.Required)
+}
+
+// members returns the members of the policy for a message with header h,
+// without duplicates.
+func (p *SignaturePolicy) members(h Header) []*identity.Address {
+	all := p.Members
+	if p.IncludeRecipients {
+		all = append(append([]*identity.Address{}, all...), h.To...)
+	}
+
+	seen := make(map[string]bool)
+	out := make([]*identity.Address, 0, len(all))
+	for _, v := range all {
+		if v == nil || v.IsPublic() || seen[v.String()] {
+			continue
+		}
+		seen[v.String()] = true
+		out = append(out, v)
+	}
+	return out
+}
+
+// check returns the members of the policy that signed s, and a *PolicyError
+// if there aren't enough of them. A signature is only counted for one member,
+// even if accepted successions make it count for more.
+func (p *SignaturePolicy) check(s *SignedMessage, h Header) ([]*identity.Address, error) {
+	members := p.members(h)
+
+	required := p.Threshold
+	if required < 0 {
+		return nil, errors.New("Signature policy has a negative threshold.")
+	} else if required > len(members) {
+		return nil, errors.New("Signature policy requires more signatures than it has members.")
+	} else if required == 0 {
+		required = len(members)
+	}
+	if required == 0 {
+		return nil, errors.New("Signature policy has no members.")
+	}
+
+	// Each signature counts for one member, preferring the member that made
+	// it over the members that handed their address over to it.
+	claimed := make(map[int]*identity.Address)
+	for _, v := range members {
+		if i := s.signatureOf(v.String()); i >= 0 && s.verifiedAddress[i] == v.String() {
+			claimed[i] = v
+		}
+	}
+	for _, v := range members {
+		if i := s.signatureOf(v.String()); i >= 0 && claimed[i] == nil {
+			claimed[i] = v
+		}
+	}
+
+	signed := make([]*identity.Address, 0, len(claimed))
+	for _, v := range members {
+		if i := s.signatureOf(v.String()); i >= 0 && claimed[i] == v {
+			signed = append(signed, v)
+		}
+	}
+
+	if len(signed) < required {
+		return signed, &PolicyError{
+			Signed:   signed,
+			Required: required,
+		}
+	}
+	return signed, nil
+}
+
+// ReconstructMessageWithPolicy will do the same thing as ReconstructMessage,
+// but it will also ensure that the signatures verified during Verify()
+// satisfy policy. It returns the members of the policy that signed the
+// message.
+//
+// Accepted successions (see AcceptSuccession) count as signatures of the
+// addresses they hand over, but each signature only counts for one member.
+func (s *SignedMessage) ReconstructMessageWithPolicy(policy *SignaturePolicy) (data []byte, messageType string, header Header, signers []*identity.Address, err error) {
+	data, messageType, header, err = s.reconstructMessage(false)
+	if err != nil {
+		return
+	}
+
+	signers, err = policy.check(s, header)
+	if err != nil {
+		return nil, "", Header{}, signers, err
+	}
+	return
+}
@@ -1,9 +1,9 @@
 package message
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
-	"math/big"
 	"time"
 
 	"airdispat.ch/crypto"
@@ -20,6 +20,7 @@ type SignedMessage struct {
 	Signature       []*wire.Signature
 	SigningFunc     []byte
 	verifiedAddress []string
+	successors      map[string]string
 }
 
 // SignMessage will sign an object that implements the message interface with an
@@ -42,37 +43,76 @@ func SignMessage(m Message, id *identity.Identity) (*SignedMessage, error) {
 		return nil, err
 	}
 
-	r, s, err := crypto.SignPayload(id.SigningKey, crypto.HashSHA(toSign))
+	signingFunc, err := id.SigningFunc()
 	if err != nil {
 		return nil, err
 	}
 
-	newSignature := &wire.Signature{
-		R:          r.Bytes(),
-		S:          s.Bytes(),
-		SigningKey: crypto.KeyToBytes(&id.SigningKey.PublicKey),
+	newSignature, err := createSignature(signingFunc, id, toSign)
+	if err != nil {
+		return nil, err
 	}
 
 	newSignedMessage := &SignedMessage{
 		Data:        toSign,
 		Signature:   []*wire.Signature{newSignature},
-		SigningFunc: crypto.SigningECDSA,
+		SigningFunc: signingFunc,
 	}
 	return newSignedMessage, nil
 }
 
+// createSignature signs the hash of data with id using the suite registered
+// for signingFunc.
+func createSignature(signingFunc []byte, id *identity.Identity, data []byte) (*wire.Signature, error) {
+	suite, err := crypto.LookupSigningSuite(signingFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	r, s, err := suite.Sign(id.SigningKey, crypto.HashSHA(data))
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey, err := suite.KeyToBytes(id.Address.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wire.Signature{
+		R:          r,
+		S:          s,
+		SigningKey: signingKey,
+	}, nil
+}
+
+// signingFunc returns the SigningFunc of the message, defaulting to ECDSA for
+// messages that did not specify one.
+func (s *SignedMessage) signingFunc() []byte {
+	if len(s.SigningFunc) == 0 {
+		return crypto.SigningECDSA
+	}
+	return s.SigningFunc
+}
+
 // AddSignature will add a new signature of the data by id onto the message.
 // This is useful if you need a SignedMessage to be signed by multiple parties.
+//
+// All signatures on a message must use the same SigningFunc, so id must be of
+// the same Kind as the original signer.
 func (s *SignedMessage) AddSignature(id *identity.Identity) error {
-	rInt, sInt, err := crypto.SignPayload(id.SigningKey, crypto.HashSHA(s.Data))
+	signingFunc, err := id.SigningFunc()
 	if err != nil {
 		return err
 	}
 
-	newSignature := &wire.Signature{
-		R:          rInt.Bytes(),
-		S:          sInt.Bytes(),
-		SigningKey: crypto.KeyToBytes(&id.SigningKey.PublicKey),
+	if !bytes.Equal(signingFunc, s.signingFunc()) {
+		return errors.New("Can't add a signature that uses a different SigningFunc.")
+	}
+
+	newSignature, err := createSignature(signingFunc, id, s.Data)
+	if err != nil {
+		return err
 	}
 
 	s.Signature = append(s.Signature, newSignature)
@@ -88,8 +128,23 @@ func (s *SignedMessage) ReconstructMessage() (data []byte, messageType string, h
 	return s.reconstructMessage(false)
 }
 
+// TimestampWindow is how far the timestamp of a message may be from the
+// current time for ReconstructMessageWithTimestamp to accept it.
+var TimestampWindow = 10 * time.Minute
+
+// TimestampError is returned by ReconstructMessageWithTimestamp when a message
+// is older or newer than TimestampWindow allows.
+type TimestampError struct {
+	Now       int64
+	Timestamp int64
+}
+
+func (e *TimestampError) Error() string {
+	return fmt.Sprintf("Couldn't verify timestamp. Now: %d, Got: %d", e.Now, e.Timestamp)
+}
+
 // ReconstructMessageWithTimestamp will do the same thing as ReconstructMessage
-// but it will ensure that the timestamp is within the last five minutes.
+// but it will ensure that the timestamp is within TimestampWindow of now.
 func (s *SignedMessage) ReconstructMessageWithTimestamp() (data []byte, messageType string, header Header, err error) {
 	return s.reconstructMessage(true)
 }
@@ -113,22 +168,19 @@ func (s *SignedMessage) reconstructMessage(ts bool) (data []byte, messageType st
 		return
 	}
 
-	verified := false
-	for _, v := range s.verifiedAddress {
-		if header.From.String() == v {
-			verified = true
-			break
-		}
-	}
-	if !verified {
+	if !s.signedBy(header.From.String()) {
 		return nil, "", Header{}, errors.New("Can't reconstruct message without a valid header.")
 	}
 
 	if ts {
 		now := time.Now().Unix()
-		if header.Timestamp < now-600 ||
-			header.Timestamp > now+600 {
-			fmt.Errorf("Couldn't verify timestamp. Now: %d, Got: %d", now, header.Timestamp)
+		window := int64(TimestampWindow / time.Second)
+		if header.Timestamp < now-window ||
+			header.Timestamp > now+window {
+			return nil, "", Header{}, &TimestampError{
+				Now:       now,
+				Timestamp: header.Timestamp,
+			}
 		}
 	}
 
@@ -152,8 +204,23 @@ func (s *SignedMessage) Encrypt(addr string, router routing.Router) (*EncryptedM
 func (s *SignedMessage) EncryptWithKey(addr *identity.Address) (*EncryptedMessage, error) {
 	if addr.IsPublic() {
 		return s.UnencryptedMessage(addr)
-	} else if addr.EncryptionKey == nil {
-		return nil, errors.New("Cannot encrypt without encryption key.")
+	}
+	return s.EncryptWithKeys(addr)
+}
+
+// EncryptWithKeys will encrypt the SignedMessage once, and add the key
+// information for every address so that each of them can decrypt it.
+func (s *SignedMessage) EncryptWithKeys(addrs ...*identity.Address) (*EncryptedMessage, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("Cannot encrypt without a recipient.")
+	}
+
+	for _, v := range addrs {
+		if v.IsPublic() {
+			return nil, errors.New("Cannot encrypt for the public address.")
+		} else if v.EncryptionKey == nil {
+			return nil, errors.New("Cannot encrypt without encryption key.")
+		}
 	}
 
 	// Create a SignedMessage Wire Object
@@ -168,8 +235,19 @@ func (s *SignedMessage) EncryptWithKey(addr *identity.Address) (*EncryptedMessag
 		return nil, err
 	}
 
-	// Encrypt the Message using HybridEncryption
-	cipher, unencryptedKey, err := crypto.EncryptDataWithRandomAESKey(bytes)
+	// Encrypt the Message using HybridEncryption. Every suite encrypts the
+	// data the same way, so the first recipient's suite works for all of them.
+	encryptionType, err := crypto.EncryptionTypeForKey(addrs[0].EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	suite, err := crypto.LookupEncryptionSuite(encryptionType)
+	if err != nil {
+		return nil, err
+	}
+
+	cipher, unencryptedKey, err := suite.EncryptData(bytes)
 	if err != nil {
 		return nil, err
 	}
@@ -179,9 +257,14 @@ func (s *SignedMessage) EncryptWithKey(addr *identity.Address) (*EncryptedMessag
 		Data:           cipher,
 		unencryptedKey: unencryptedKey,
 	}
-	err = encryptionMessage.AddRecipient(addr)
+	for _, v := range addrs {
+		err = encryptionMessage.AddRecipient(v)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	return encryptionMessage, err
+	return encryptionMessage, nil
 }
 
 // UnencryptedMessage will take a signed message and an address and turn it into
@@ -206,10 +289,53 @@ func (s *SignedMessage) UnencryptedMessage(addr *identity.Address) (*EncryptedMe
 	return encryptionMessage, nil
 }
 
+// AcceptSuccession lets ReconstructMessage accept a message from the address
+// handed over by a Succession when it is signed by the successor instead.
+// Successions may be chained. Only successions returned by VerifySuccession
+// (or LookupSuccession) are accepted, and any others are ignored.
+func (s *SignedMessage) AcceptSuccession(succ ...*Succession) {
+	if s.successors == nil {
+		s.successors = make(map[string]string)
+	}
+	for _, v := range succ {
+		if v == nil || !v.verified {
+			continue
+		}
+		s.successors[v.Address.String()] = v.Successor.String()
+	}
+}
+
+// signedBy reports whether addr, or one of its accepted successors, had a
+// signature verified during Verify().
+func (s *SignedMessage) signedBy(addr string) bool {
+	return s.signatureOf(addr) >= 0
+}
+
+// signatureOf returns the index of the signature verified during Verify()
+// that was made by addr, or by the first of its accepted successors that
+// signed, or -1 if there isn't one.
+func (s *SignedMessage) signatureOf(addr string) int {
+	// Every succession is followed at most once, in case they form a loop
+	for i := 0; i <= len(s.successors); i++ {
+		for j, v := range s.verifiedAddress {
+			if addr == v {
+				return j
+			}
+		}
+
+		next, ok := s.successors[addr]
+		if !ok {
+			return -1
+		}
+		addr = next
+	}
+	return -1
+}
+
 // Verify that a signed message is genuine.
 //
-// Unwinds the R and S values of the ECDSA keypair from the Airdispatch Signature
-// then passes them to the verifyPayload function.
+// Looks up the signing suite registered for the SigningFunc of the message and
+// passes each Airdispatch Signature to it.
 //
 // Additionally, it will save which addresses had signatures that were verified
 // to match with Header{} verification later.
@@ -222,20 +348,14 @@ func (s *SignedMessage) Verify() bool {
 		return false
 	}
 
+	suite, err := crypto.LookupSigningSuite(s.signingFunc())
+	if err != nil {
+		return false
+	}
+
 	s.verifiedAddress = make([]string, len(s.Signature))
 	for i, signature := range s.Signature {
-		// Create an ECDSA Key from the Bytes
-		key, err := crypto.BytesToKey(signature.SigningKey)
-		if err != nil {
-			return false
-		}
-
-		// Reconstruct the Signature
-		var rSig, sSig *big.Int = new(big.Int), new(big.Int)
-		rSig.SetBytes(signature.GetR())
-		sSig.SetBytes(signature.GetS())
-
-		if !crypto.VerifyPayload(key, hash, rSig, sSig) {
+		if !suite.Verify(signature.SigningKey, hash, signature.GetR(), signature.GetS()) {
 			return false
 		}
 
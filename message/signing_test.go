@@ -0,0 +1,295 @@
+package message
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"airdispat.ch/identity"
+	"airdispat.ch/wire"
+)
+
+func testRoundTrip(t *testing.T, sender *identity.Identity, receiver *identity.Identity) {
+	mail := CreateMail(sender.Address, time.Now(), "test", receiver.Address)
+	mail.Components.AddComponent(CreateStringComponent("test", "hello world"))
+
+	signed, err := SignMessage(mail, sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := signed.EncryptWithKey(receiver.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	by, err := enc.ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received, err := CreateEncryptedMessageFromBytes(by)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, typ, h, err := received.Reconstruct(receiver, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if typ != wire.MailCode {
+		t.Fatal("Wrong message type", typ)
+	}
+
+	output, err := CreateMailFromBytes(data, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output.Components.GetStringComponent("test") != "hello world" {
+		t.Error("Component did not survive the round trip.")
+	}
+}
+
+func TestRoundTripKinds(t *testing.T) {
+	kinds := []identity.Kind{identity.KindRSA, identity.KindCurve25519}
+	for _, senderKind := range kinds {
+		for _, receiverKind := range kinds {
+			sender, err := identity.CreateIdentityOfKind(senderKind)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			receiver, err := identity.CreateIdentityOfKind(receiverKind)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			testRoundTrip(t, sender, receiver)
+		}
+	}
+}
+
+func TestSignAndSendToMany(t *testing.T) {
+	sender, err := identity.CreateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each server records the recipients of the messages it receives
+	received := make(chan map[string]bool, 4)
+	listen := func() string {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			defer listener.Close()
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			msg, err := ReadMessageFromConnection(conn)
+			if err != nil {
+				received <- nil
+				return
+			}
+			keys := make(map[string]bool)
+			for k := range msg.Header {
+				keys[k] = true
+			}
+			received <- keys
+		}()
+		return listener.Addr().String()
+	}
+	serverA, serverB := listen(), listen()
+
+	var to []*identity.Address
+	for _, location := range []string{serverA, serverA, serverB, ""} {
+		id, err := identity.CreateIdentityOfKind(identity.KindCurve25519)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id.Address.Location = location
+		to = append(to, id.Address)
+	}
+
+	mail := CreateMail(sender.Address, time.Now(), "test", to...)
+	deliveries, err := SignAndSendToMany(mail, sender, to...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, v := range deliveries {
+		if v.To != to[i] {
+			t.Error("Deliveries are not in the order of the recipients.")
+		}
+		if (v.Error == nil) != (to[i].Location != "") {
+			t.Error("Unexpected delivery result for recipient", i, v.Error)
+		}
+	}
+
+	copies := []map[string]bool{<-received, <-received}
+	for _, keys := range copies {
+		switch len(keys) {
+		case 2:
+			if !keys[to[0].String()] || !keys[to[1].String()] {
+				t.Error("Server A received the wrong recipients.")
+			}
+		case 1:
+			if !keys[to[2].String()] {
+				t.Error("Server B received the wrong recipients.")
+			}
+		default:
+			t.Error("Server received unexpected recipients", keys)
+		}
+	}
+}
+
+func TestAcceptSuccession(t *testing.T) {
+	old, err := identity.CreateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	successor, err := identity.CreateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	by, err := SignSuccession(old, successor.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	succ, err := VerifySuccession(by)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !succ.Address.EqualsBytes(old.Address.Fingerprint) || !succ.Successor.EqualsBytes(successor.Address.Fingerprint) {
+		t.Error("Succession doesn't hand the old address over to the successor.")
+	}
+
+	// Only the old key can hand its address over.
+	forged := CreateSuccession(old, successor.Address)
+	signed, err := SignMessage(forged, successor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, _ := signed.UnencryptedMessage(identity.Public)
+	if _, err := VerifySuccession(enc.Data); err == nil {
+		t.Error("Expected succession signed by the successor to fail verification.")
+	}
+
+	// Messages from the old address signed by the successor are only
+	// accepted when asked for.
+	mail := CreateMail(old.Address, time.Now(), "test")
+	signed, err = SignMessage(mail, successor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !signed.Verify() {
+		t.Fatal("Unable to verify message.")
+	}
+
+	if _, _, _, err := signed.ReconstructMessage(); err == nil {
+		t.Error("Expected message from successor to be rejected.")
+	}
+
+	// Successions that haven't been verified are ignored.
+	signed.AcceptSuccession(CreateSuccession(old, successor.Address))
+	if _, _, _, err := signed.ReconstructMessage(); err == nil {
+		t.Error("Expected unverified succession to be ignored.")
+	}
+
+	signed.AcceptSuccession(succ)
+	_, _, h, err := signed.ReconstructMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.From.EqualsBytes(old.Address.Fingerprint) {
+		t.Error("Expected message to be from the old address.")
+	}
+}
+
+func TestSignaturePolicy(t *testing.T) {
+	ids := make([]*identity.Identity, 3)
+	for i := range ids {
+		id, err := identity.CreateIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+
+	mail := CreateMail(ids[0].Address, time.Now(), "approval", ids[1].Address, ids[2].Address)
+	signed, err := SignMessage(mail, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signed.AddSignature(ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if !signed.Verify() {
+		t.Fatal("Unable to verify message.")
+	}
+
+	_, _, _, signers, err := signed.ReconstructMessageWithPolicy(CreateThresholdPolicy(2, ids[0].Address, ids[1].Address, ids[2].Address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 2 || signers[0] != ids[0].Address || signers[1] != ids[1].Address {
+		t.Error("Expected the first two members to have signed, got", signers)
+	}
+
+	_, _, _, signers, err = signed.ReconstructMessageWithPolicy(CreateRecipientsPolicy())
+	if e, ok := err.(*PolicyError); !ok || e.Required != 2 || len(signers) != 1 {
+		t.Error("Expected a missing recipient signature to fail the policy, got", err, signers)
+	}
+
+	if err := signed.AddSignature(ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	if !signed.Verify() {
+		t.Fatal("Unable to verify message.")
+	}
+	if _, _, _, _, err := signed.ReconstructMessageWithPolicy(CreateRecipientsPolicy()); err != nil {
+		t.Error("Expected every recipient to have signed, got", err)
+	}
+
+	// Thresholds that can never be met are rejected, even though every
+	// member signed.
+	for _, threshold := range []int{-1, 4} {
+		_, _, _, _, err = signed.ReconstructMessageWithPolicy(CreateThresholdPolicy(threshold, ids[0].Address, ids[1].Address, ids[2].Address))
+		if _, ok := err.(*PolicyError); ok || err == nil {
+			t.Error("Expected a threshold of", threshold, "to be invalid, got", err)
+		}
+	}
+
+	// A signature only counts once, even for a member that handed its
+	// address over to another member.
+	by, err := SignSuccession(ids[0], ids[2].Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	succ, err := VerifySuccession(by)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err = SignMessage(mail, ids[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !signed.Verify() {
+		t.Fatal("Unable to verify message.")
+	}
+	signed.AcceptSuccession(succ)
+
+	_, _, _, signers, err = signed.ReconstructMessageWithPolicy(CreateThresholdPolicy(2, ids[0].Address, ids[1].Address, ids[2].Address))
+	if e, ok := err.(*PolicyError); !ok || len(e.Signed) != 1 || signers[0] != ids[2].Address {
+		t.Error("Expected one signature to count for one member, got", err, signers)
+	}
+}
@@ -0,0 +1,176 @@
+package message
+
+import (
+	"bytes"
+	"errors"
+
+	"airdispat.ch/crypto"
+	"airdispat.ch/identity"
+	"airdispat.ch/routing"
+	"airdispat.ch/wire"
+	"code.google.com/p/goprotobuf/proto"
+)
+
+var ErrInvalidSuccession = errors.New("Succession is not signed by the address it hands over.")
+
+// Succession hands an address over to a new key, so that a user who rotates
+// (or loses) their key keeps their address. It is signed by the key of the
+// old address (see SignSuccession).
+type Succession struct {
+	Address   *identity.Address
+	Successor *identity.Address
+	h         Header
+
+	// Only set by VerifySuccession
+	verified bool
+}
+
+// CreateSuccession will return a Succession from the address of old to the
+// keys of successor.
+func CreateSuccession(old *identity.Identity, successor *identity.Address) *Succession {
+	return &Succession{
+		Address:   old.Address,
+		Successor: successor,
+		h:         CreateHeader(old.Address),
+	}
+}
+
+// CreateSuccessionFromBytes will unmarshal a Succession given its bytes and
+// header.
+func CreateSuccessionFromBytes(by []byte, h Header) (*Succession, error) {
+	unmarsh := &wire.KeySuccession{}
+	err := proto.Unmarshal(by, unmarsh)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptionKey, err := crypto.BytesToEncryptionKey(unmarsh.GetEncryptionKey())
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey, err := crypto.BytesToSigningKey(unmarsh.GetSigningKey())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Succession{
+		Address: identity.CreateAddressFromBytes(unmarsh.GetAddress()),
+		Successor: &identity.Address{
+			Fingerprint:   unmarsh.GetSuccessor(),
+			EncryptionKey: encryptionKey,
+			SigningKey:    signingKey,
+		},
+		h: h,
+	}, nil
+}
+
+// ToBytes will marshal a Succession to its component bytes.
+func (m *Succession) ToBytes() []byte {
+	toData := &wire.KeySuccession{
+		Address:       m.Address.Fingerprint,
+		Successor:     m.Successor.Fingerprint,
+		EncryptionKey: crypto.EncryptionKeyToBytes(m.Successor.EncryptionKey),
+		SigningKey:    crypto.SigningKeyToBytes(m.Successor.SigningKey),
+	}
+	by, err := proto.Marshal(toData)
+	if err != nil {
+		panic("Can't marshal Succession.")
+	}
+	return by
+}
+
+// Type returns wire.SuccessionCode (or "SUC").
+func (m *Succession) Type() string {
+	return wire.SuccessionCode
+}
+
+// Header just returns the stored header with the message.
+func (m *Succession) Header() Header {
+	return m.h
+}
+
+// SignSuccession signs a Succession from the address of old to successor
+// with the key of old, and returns the bytes that routers store and clients
+// verify with VerifySuccession.
+//
+// Trackers keep the first succession they are given for an address, so
+// anyone holding the old key (including someone who stole it) can hand the
+// address over for good. Users who worry about losing their key should hand
+// their address over to a key kept offline before that happens.
+func SignSuccession(old *identity.Identity, successor *identity.Address) ([]byte, error) {
+	if successor.SigningKey == nil || successor.EncryptionKey == nil {
+		return nil, errors.New("Cannot hand an address over to a successor without keys.")
+	}
+
+	signed, err := SignMessage(CreateSuccession(old, successor), old)
+	if err != nil {
+		return nil, err
+	}
+
+	unencrypted, err := signed.UnencryptedMessage(identity.Public)
+	if err != nil {
+		return nil, err
+	}
+	return unencrypted.Data, nil
+}
+
+// VerifySuccession checks that a signed Succession was signed by the address
+// that it hands over, and that the keys of the successor match its address.
+func VerifySuccession(by []byte) (*Succession, error) {
+	signed, err := (&EncryptedMessage{Data: by}).UnencryptedMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	if !signed.Verify() {
+		return nil, ErrInvalidSuccession
+	}
+
+	data, typ, h, err := signed.ReconstructMessage()
+	if err != nil {
+		return nil, err
+	} else if typ != wire.SuccessionCode {
+		return nil, errors.New("Signed message is not a Succession.")
+	}
+
+	succ, err := CreateSuccessionFromBytes(data, h)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint := crypto.BytesToAddress(crypto.SigningKeyToBytes(succ.Successor.SigningKey))
+	if !h.From.EqualsBytes(succ.Address.Fingerprint) ||
+		!bytes.Equal(succ.Successor.Fingerprint, fingerprint) {
+		return nil, ErrInvalidSuccession
+	}
+	succ.verified = true
+	return succ, nil
+}
+
+// LookupSuccession asks a router for the succession of an address, and
+// verifies it.
+func LookupSuccession(router routing.Router, addr string) (*Succession, error) {
+	r, ok := router.(routing.SuccessionRouter)
+	if !ok {
+		return nil, routing.ErrNoSuccessions
+	}
+
+	query, err := identity.CreateAddressFromString(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := r.LookupSuccession(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	succ, err := VerifySuccession(signed)
+	if err != nil {
+		return nil, err
+	} else if !succ.Address.EqualsBytes(query.Fingerprint) {
+		return nil, ErrInvalidSuccession
+	}
+	return succ, nil
+}
@@ -0,0 +1,217 @@
+package message
+
+import (
+	"sort"
+	"time"
+
+	"airdispat.ch/identity"
+	"airdispat.ch/wire"
+)
+
+// Reference identifies a Mail by its author and name.
+type Reference struct {
+	Author *identity.Address
+	Name   string
+}
+
+// String returns the fingerprint of the author and the name of the mail.
+func (r Reference) String() string {
+	return r.Author.String() + "/" + r.Name
+}
+
+// The wire format requires an author, so references without one are
+// dropped.
+func (r *Reference) toWire() *wire.Mail_Reference {
+	if r == nil || r.Author == nil || len(r.Author.Fingerprint) == 0 {
+		return nil
+	}
+	return &wire.Mail_Reference{
+		Author: r.Author.Fingerprint,
+		Name:   &r.Name,
+	}
+}
+
+func createReferenceFromWire(w *wire.Mail_Reference) *Reference {
+	if w == nil {
+		return nil
+	}
+	return &Reference{
+		Author: identity.CreateAddressFromBytes(w.GetAuthor()),
+		Name:   w.GetName(),
+	}
+}
+
+func createReferencesFromWire(w []*wire.Mail_Reference) []Reference {
+	if len(w) == 0 {
+		return nil
+	}
+	out := make([]Reference, len(w))
+	for i, v := range w {
+		out[i] = *createReferenceFromWire(v)
+	}
+	return out
+}
+
+// Reference returns the Reference that other mail uses to refer to this one.
+func (m *Mail) Reference() Reference {
+	return Reference{
+		Author: m.h.From,
+		Name:   m.Name,
+	}
+}
+
+// CreateReply will return a new Mail that replies to parent, in the same
+// conversation.
+func CreateReply(parent *Mail, from *identity.Address, ts time.Time, name string, to ...*identity.Address) *Mail {
+	m := CreateMail(from, ts, name, to...)
+
+	ref := parent.Reference()
+	m.InReplyTo = &ref
+	thread := ref
+	if parent.Thread != nil {
+		thread = *parent.Thread
+	}
+	m.Thread = &thread
+	m.References = append(append([]Reference{}, parent.References...), ref)
+	return m
+}
+
+// ancestors returns the mail that m refers to, from the first mail of the
+// conversation to the mail that it replies to, without duplicates.
+func (m *Mail) ancestors() []Reference {
+	var refs []Reference
+	if m.Thread != nil {
+		refs = append(refs, *m.Thread)
+	}
+	refs = append(refs, m.References...)
+	if m.InReplyTo != nil {
+		refs = append(refs, *m.InReplyTo)
+	}
+
+	seen := map[string]bool{
+		m.Reference().String(): true,
+	}
+	out := make([]Reference, 0, len(refs))
+	for _, v := range refs {
+		if v.Author == nil || seen[v.String()] {
+			continue
+		}
+		seen[v.String()] = true
+		out = append(out, v)
+	}
+	return out
+}
+
+// Conversation is a mail in a conversation tree, along with its replies.
+//
+// Mail is nil if another mail refers to it but it wasn't given to
+// BuildConversations, so that its replies are still kept together.
+type Conversation struct {
+	Reference Reference
+	Mail      *Mail
+	Replies   []*Conversation
+
+	parent *Conversation
+}
+
+// BuildConversations arranges mail into conversation trees, and returns the
+// root of each of them. Roots and replies are sorted by the time of their
+// earliest mail.
+func BuildConversations(mails ...*Mail) []*Conversation {
+	nodes := make(map[string]*Conversation)
+	var order []*Conversation
+	node := func(ref Reference) *Conversation {
+		n, ok := nodes[ref.String()]
+		if !ok {
+			n = &Conversation{Reference: ref}
+			nodes[ref.String()] = n
+			order = append(order, n)
+		}
+		return n
+	}
+
+	for _, m := range mails {
+		if n := node(m.Reference()); n.Mail == nil {
+			n.Mail = m
+		}
+	}
+
+	// A mail knows which mail it replies to best
+	for _, m := range mails {
+		refs := m.ancestors()
+		if len(refs) > 0 {
+			link(node(refs[len(refs)-1]), node(m.Reference()))
+		}
+	}
+
+	// Missing mail is placed using the references of its replies
+	for _, m := range mails {
+		refs := m.ancestors()
+		for i := 1; i < len(refs); i++ {
+			link(node(refs[i-1]), node(refs[i]))
+		}
+	}
+
+	var roots []*Conversation
+	for _, v := range order {
+		if v.parent == nil {
+			roots = append(roots, v)
+		} else {
+			v.parent.Replies = append(v.parent.Replies, v)
+		}
+	}
+
+	sortConversations(roots)
+	return roots
+}
+
+// Makes child a reply to parent, unless child already has a parent or it
+// would create a loop
+func link(parent *Conversation, child *Conversation) {
+	if child.parent != nil {
+		return
+	}
+	for p := parent; p != nil; p = p.parent {
+		if p == child {
+			return
+		}
+	}
+	child.parent = parent
+}
+
+func sortConversations(c []*Conversation) {
+	for _, v := range c {
+		sortConversations(v.Replies)
+	}
+	sort.SliceStable(c, func(i, j int) bool {
+		return c[i].earliest() < c[j].earliest()
+	})
+}
+
+// Returns the earliest timestamp of the mail in the conversation. Replies are
+// sorted first, so only the first reply needs to be checked.
+func (c *Conversation) earliest() int64 {
+	var t int64
+	if c.Mail != nil {
+		t = c.Mail.h.Timestamp
+	}
+	if len(c.Replies) > 0 {
+		if r := c.Replies[0].earliest(); c.Mail == nil || r < t {
+			t = r
+		}
+	}
+	return t
+}
+
+// Walk calls fn for the conversation and each of its replies, depth first,
+// along with how deep each of them is in the tree.
+func (c *Conversation) Walk(fn func(c *Conversation, depth int)) {
+	c.walk(fn, 0)
+}
+
+func (c *Conversation) walk(fn func(c *Conversation, depth int), depth int) {
+	fn(c, depth)
+	for _, v := range c.Replies {
+		v.walk(fn, depth+1)
+	}
+}
@@ -0,0 +1,78 @@
+package message
+
+import (
+	"testing"
+	"time"
+
+	"airdispat.ch/identity"
+)
+
+func TestBuildConversations(t *testing.T) {
+	alice := identity.CreateAddressFromBytes([]byte("alice"))
+	bob := identity.CreateAddressFromBytes([]byte("bob"))
+	now := time.Now()
+
+	root := CreateMail(alice, now, "root", bob)
+	reply := CreateReply(root, bob, now.Add(time.Minute), "reply", alice)
+	missing := CreateReply(reply, alice, now.Add(2*time.Minute), "missing", bob)
+	late := CreateReply(missing, bob, now.Add(3*time.Minute), "late", alice)
+	other := CreateMail(bob, now.Add(-time.Hour), "other", alice)
+
+	// Conversation metadata survives the wire.
+	received, err := CreateMailFromBytes(late.ToBytes(), late.Header())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.InReplyTo.String() != missing.Reference().String() ||
+		received.Thread.String() != root.Reference().String() ||
+		len(received.References) != 3 {
+		t.Error("Conversation metadata didn't survive the wire.", received.InReplyTo, received.Thread, received.References)
+	}
+
+	roots := BuildConversations(received, root, other, reply)
+	if len(roots) != 2 || roots[0].Mail != other || roots[1].Mail != root {
+		t.Fatal("Expected two conversations sorted by time, got", roots)
+	}
+
+	var names []string
+	roots[1].Walk(func(c *Conversation, depth int) {
+		if len(names) != depth {
+			t.Error("Expected", c.Reference, "at depth", len(names), "got", depth)
+		}
+		names = append(names, c.Reference.Name)
+		if (c.Mail == nil) != (c.Reference.Name == "missing") {
+			t.Error("Expected only the missing mail to have no Mail.", c.Reference)
+		}
+	})
+	if len(names) != 4 || names[3] != "late" {
+		t.Error("Expected a chain of four mails, got", names)
+	}
+}
+
+func TestReferencesWithoutAuthor(t *testing.T) {
+	alice := identity.CreateAddressFromBytes([]byte("alice"))
+	bob := identity.CreateAddressFromBytes([]byte("bob"))
+	now := time.Now()
+
+	root := CreateMail(alice, now, "root", bob)
+	reply := CreateReply(root, bob, now, "reply", alice)
+
+	// Replies don't share the thread of their parent.
+	again := CreateReply(reply, alice, now, "again", bob)
+	again.Thread.Name = "changed"
+	if reply.Thread.Name != "root" {
+		t.Error("Expected replies to copy the thread of their parent, got", reply.Thread)
+	}
+
+	reply.Thread = &Reference{Name: "no author"}
+	reply.InReplyTo.Author = &identity.Address{}
+	reply.References = append(reply.References, Reference{Name: "no author"})
+
+	received, err := CreateMailFromBytes(reply.ToBytes(), reply.Header())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Thread != nil || received.InReplyTo != nil || len(received.References) != 1 {
+		t.Error("Expected references without an author to be dropped.", received.Thread, received.InReplyTo, received.References)
+	}
+}
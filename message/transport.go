@@ -0,0 +1,109 @@
+package message
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+
+	"airdispat.ch/identity"
+)
+
+// Transport abstracts how connections between AirDispatch clients and
+// servers are made.
+type Transport interface {
+	// Dial connects to the server at remote. If server is not nil, the
+	// transport may use it to authenticate the server it connected to.
+	Dial(remote string, server *identity.Address) (net.Conn, error)
+	// Listen accepts connections on a local address.
+	Listen(address string) (net.Listener, error)
+}
+
+// DefaultTransport is used by ConnectToServer and every function that
+// connects to a server on its own.
+var DefaultTransport Transport = TCPTransport{}
+
+// TCPTransport sends messages over plain TCP.
+type TCPTransport struct{}
+
+func (TCPTransport) Dial(remote string, server *identity.Address) (net.Conn, error) {
+	address, err := net.ResolveTCPAddr("tcp", remote)
+	if err != nil {
+		return nil, err
+	}
+
+	// Connect to the Remote Mail Server
+	conn, err := net.DialTCP("tcp", nil, address)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (TCPTransport) Listen(address string) (net.Listener, error) {
+	return net.Listen("tcp", address)
+}
+
+// TLSTransport sends messages over TLS, so that message types, sizes and
+// public messages are not visible on the network.
+//
+// When dialing a known server, the server's certificate is pinned to its
+// AirDispatch address (see identity.Identity.TLSCertificate) instead of being
+// checked against certificate authorities.
+type TLSTransport struct {
+	Config *tls.Config
+	// Servers are the addresses of servers by location, for connections
+	// that are made without one (such as to deliver alerts, which are
+	// encrypted for the recipients rather than their server). It must not
+	// be changed while the transport is in use.
+	Servers map[string]*identity.Address
+}
+
+// CreateTLSTransport returns a TLSTransport that presents a self-signed
+// certificate for id.
+func CreateTLSTransport(id *identity.Identity) (*TLSTransport, error) {
+	cert, err := id.TLSCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TLSTransport{
+		Config: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		},
+	}, nil
+}
+
+func (t *TLSTransport) config() *tls.Config {
+	if t.Config == nil {
+		return &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return t.Config.Clone()
+}
+
+func (t *TLSTransport) Dial(remote string, server *identity.Address) (net.Conn, error) {
+	config := t.config()
+	if server == nil {
+		server = t.Servers[remote]
+	}
+	if server != nil && server.Fingerprint != nil {
+		// The certificate is checked against the server's address below
+		// rather than against certificate authorities.
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return server.VerifyTLSCertificate(rawCerts)
+		}
+	}
+
+	return tls.Dial("tcp", remote, config)
+}
+
+func (t *TLSTransport) Listen(address string) (net.Listener, error) {
+	config := t.config()
+	if len(config.Certificates) == 0 && config.GetCertificate == nil {
+		return nil, errors.New("Can't listen for TLS without a certificate.")
+	}
+
+	return tls.Listen("tcp", address, config)
+}
@@ -0,0 +1,81 @@
+package message
+
+import (
+	"io"
+	"testing"
+
+	"airdispat.ch/identity"
+)
+
+func TestTLSTransportPinning(t *testing.T) {
+	server, err := identity.CreateIdentityOfKind(identity.KindCurve25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := identity.CreateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverTransport, err := CreateTLSTransport(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := serverTransport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	client := &TLSTransport{}
+	remote := listener.Addr().String()
+
+	conn, err := client.Dial(remote, server.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("ping"))
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+		t.Error("Unable to talk over pinned TLS connection.", err)
+	}
+	conn.Close()
+
+	// A certificate for a different address must be rejected.
+	conn, err = client.Dial(remote, other.Address)
+	if err == nil {
+		conn.Close()
+		t.Error("Expected certificate for the wrong address to be rejected.")
+	}
+
+	// Without a pin, the self-signed certificate is not trusted.
+	conn, err = client.Dial(remote, nil)
+	if err == nil {
+		conn.Close()
+		t.Error("Expected unpinned self-signed certificate to be rejected.")
+	}
+
+	// Known servers are pinned when no address is given.
+	client.Servers = map[string]*identity.Address{remote: server.Address}
+	conn, err = client.Dial(remote, nil)
+	if err != nil {
+		t.Error("Unable to dial a known server.", err)
+	} else {
+		conn.Close()
+	}
+}
@@ -0,0 +1,29 @@
+package routing
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidAlias = errors.New("Alias must be of the form user@domain.")
+
+// SplitAlias splits an alias of the form user@domain into its user and
+// domain. The domain names the tracker that is authoritative for the alias.
+func SplitAlias(alias string) (user string, domain string, err error) {
+	i := strings.LastIndex(alias, "@")
+	if i <= 0 || i == len(alias)-1 {
+		return "", "", ErrInvalidAlias
+	}
+
+	user, domain = alias[:i], strings.ToLower(alias[i+1:])
+	if strings.ContainsAny(user, " \t\n") || strings.ContainsAny(domain, " \t\n@") {
+		return "", "", ErrInvalidAlias
+	}
+	return user, domain, nil
+}
+
+// IsDomainAlias reports whether an alias is of the form user@domain.
+func IsDomainAlias(alias string) bool {
+	_, _, err := SplitAlias(alias)
+	return err == nil
+}
@@ -0,0 +1,18 @@
+package routing
+
+import (
+	"testing"
+)
+
+func TestSplitAlias(t *testing.T) {
+	user, domain, err := SplitAlias("first.last@sub@Example.COM")
+	if err != nil || user != "first.last@sub" || domain != "example.com" {
+		t.Error("Unexpected split of alias.", user, domain, err)
+	}
+
+	for _, v := range []string{"", "alice", "@example.com", "alice@", "al ice@example.com"} {
+		if IsDomainAlias(v) {
+			t.Error("Expected", v, "not to be a domain alias.")
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package routing
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"airdispat.ch/identity"
+)
+
+var ErrAddressNotFound = errors.New("Unable to find address.")
+
+// IsNotFound reports whether a lookup failed because the router doesn't know
+// the address, rather than because the router couldn't be reached. Errors
+// may say so by having a NotFound() bool method.
+func IsNotFound(err error) bool {
+	if e, ok := err.(interface {
+		NotFound() bool
+	}); ok {
+		return e.NotFound()
+	}
+	return err == ErrAddressNotFound
+}
+
+// ExpiringRouter is a Router that knows when the result of a lookup stops
+// being valid, such as when the registration it came from expires.
+type ExpiringRouter interface {
+	Router
+	LookupWithExpiry(addr string, name LookupType) (*identity.Address, time.Time, error)
+	LookupAliasWithExpiry(alias string, name LookupType) (*identity.Address, time.Time, error)
+}
+
+// CachingRouter remembers the lookups made with another Router.
+//
+// Addresses are kept for TTL, or until they expire if the Router is an
+// ExpiringRouter and that is sooner. Lookups that fail because the address
+// can't be found are kept for NegativeTTL. Once there are MaxEntries lookups
+// cached, the least recently used are forgotten.
+//
+// Concurrent lookups that miss the cache for the same key share one lookup
+// of the Router.
+type CachingRouter struct {
+	Router      Router
+	TTL         time.Duration
+	NegativeTTL time.Duration
+	MaxEntries  int
+
+	lock     sync.Mutex
+	entries  map[cacheKey]*list.Element
+	order    *list.List
+	inFlight map[cacheKey]*cacheCall
+}
+
+type cacheKey struct {
+	alias bool
+	key   string
+	name  LookupType
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	addr    *identity.Address
+	err     error
+	expires time.Time
+}
+
+// A lookup of the underlying Router that other lookups are waiting for.
+// Invalidations made while it runs are kept, so that a result from before
+// them isn't cached.
+type cacheCall struct {
+	done        chan struct{}
+	addr        *identity.Address
+	err         error
+	invalidated []func(e *cacheEntry) bool
+}
+
+func CreateCachingRouter(r Router) *CachingRouter {
+	return &CachingRouter{
+		Router:      r,
+		TTL:         10 * time.Minute,
+		NegativeTTL: time.Minute,
+		MaxEntries:  1024,
+	}
+}
+
+// Register registers the identity with the underlying Router, and forgets
+// what was cached for it.
+func (c *CachingRouter) Register(id *identity.Identity, alias string, redirects map[string]Redirect) error {
+	err := c.Router.Register(id, alias, redirects)
+	c.Invalidate(id.Address.String())
+	if alias != "" {
+		c.InvalidateAlias(alias)
+	}
+	return err
+}
+
+// RegisterSuccession passes a signed succession on to the underlying Router.
+// Successions aren't cached.
+func (c *CachingRouter) RegisterSuccession(signed []byte) error {
+	r, ok := c.Router.(SuccessionRouter)
+	if !ok {
+		return ErrNoSuccessions
+	}
+	return r.RegisterSuccession(signed)
+}
+
+// LookupSuccession asks the underlying Router for the succession of an
+// address.
+func (c *CachingRouter) LookupSuccession(addr string) ([]byte, error) {
+	r, ok := c.Router.(SuccessionRouter)
+	if !ok {
+		return nil, ErrNoSuccessions
+	}
+	return r.LookupSuccession(addr)
+}
+
+func (c *CachingRouter) Lookup(addr string, name LookupType) (*identity.Address, error) {
+	return c.lookup(cacheKey{false, addr, name}, func() (*identity.Address, time.Time, error) {
+		if r, ok := c.Router.(ExpiringRouter); ok {
+			return r.LookupWithExpiry(addr, name)
+		}
+		found, err := c.Router.Lookup(addr, name)
+		return found, time.Time{}, err
+	})
+}
+
+func (c *CachingRouter) LookupAlias(alias string, name LookupType) (*identity.Address, error) {
+	return c.lookup(cacheKey{true, alias, name}, func() (*identity.Address, time.Time, error) {
+		if r, ok := c.Router.(ExpiringRouter); ok {
+			return r.LookupAliasWithExpiry(alias, name)
+		}
+		found, err := c.Router.LookupAlias(alias, name)
+		return found, time.Time{}, err
+	})
+}
+
+// Invalidate forgets every cached lookup of an address, along with every
+// cached lookup that found it.
+func (c *CachingRouter) Invalidate(addr string) {
+	c.invalidate(func(e *cacheEntry) bool {
+		return (!e.key.alias && e.key.key == addr) || (e.addr != nil && e.addr.String() == addr)
+	})
+}
+
+// InvalidateAlias forgets every cached lookup of an alias.
+func (c *CachingRouter) InvalidateAlias(alias string) {
+	c.invalidate(func(e *cacheEntry) bool {
+		return e.key.alias && e.key.key == alias
+	})
+}
+
+// Flush forgets every cached lookup.
+func (c *CachingRouter) Flush() {
+	c.invalidate(func(e *cacheEntry) bool {
+		return true
+	})
+}
+
+func (c *CachingRouter) lookup(key cacheKey, lookup func() (*identity.Address, time.Time, error)) (*identity.Address, error) {
+	now := time.Now()
+
+	c.lock.Lock()
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		if now.Before(entry.expires) {
+			c.order.MoveToFront(el)
+			c.lock.Unlock()
+			return copyAddress(entry.addr), entry.err
+		}
+		c.remove(el)
+	}
+
+	if call, ok := c.inFlight[key]; ok {
+		c.lock.Unlock()
+		<-call.done
+		return copyAddress(call.addr), call.err
+	}
+
+	call := &cacheCall{
+		done: make(chan struct{}),
+	}
+	if c.inFlight == nil {
+		c.inFlight = make(map[cacheKey]*cacheCall)
+	}
+	c.inFlight[key] = call
+	c.lock.Unlock()
+
+	entry := c.fetch(key, now, lookup)
+	call.addr, call.err = entry.addr, entry.err
+
+	c.lock.Lock()
+	delete(c.inFlight, key)
+	if entry.expires.After(now) && !call.stale(entry) {
+		c.add(entry)
+	}
+	c.lock.Unlock()
+	close(call.done)
+
+	return copyAddress(call.addr), call.err
+}
+
+// Looks up an address with the underlying Router, and returns the entry to
+// cache for it. Entries without an expiry aren't cached.
+func (c *CachingRouter) fetch(key cacheKey, now time.Time, lookup func() (*identity.Address, time.Time, error)) *cacheEntry {
+	found, expires, err := lookup()
+	entry := &cacheEntry{
+		key: key,
+	}
+
+	if err == nil && (found == nil || found.IsPublic()) {
+		entry.addr = found
+	} else if err == nil {
+		entry.addr = copyAddress(found)
+		entry.addr.Cache()
+		entry.expires = now.Add(c.TTL)
+		if !expires.IsZero() && expires.Before(entry.expires) {
+			entry.expires = expires
+		}
+	} else if IsNotFound(err) {
+		entry.err = err
+		entry.expires = now.Add(c.NegativeTTL)
+	} else {
+		// Don't remember that the router couldn't be reached
+		entry.err = err
+	}
+	return entry
+}
+
+func (c *CachingRouter) add(entry *cacheEntry) {
+	if c.entries == nil {
+		c.entries = make(map[cacheKey]*list.Element)
+		c.order = list.New()
+	}
+
+	if el, ok := c.entries[entry.key]; ok {
+		c.remove(el)
+	}
+	c.entries[entry.key] = c.order.PushFront(entry)
+
+	for c.MaxEntries > 0 && c.order.Len() > c.MaxEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *CachingRouter) remove(el *list.Element) {
+	delete(c.entries, el.Value.(*cacheEntry).key)
+	c.order.Remove(el)
+}
+
+func (c *CachingRouter) invalidate(match func(e *cacheEntry) bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, call := range c.inFlight {
+		call.invalidated = append(call.invalidated, match)
+	}
+
+	if c.order == nil {
+		return
+	}
+
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if match(el.Value.(*cacheEntry)) {
+			c.remove(el)
+		}
+		el = next
+	}
+}
+
+// Reports whether an invalidation made during the call covers its result.
+func (call *cacheCall) stale(entry *cacheEntry) bool {
+	for _, match := range call.invalidated {
+		if match(entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// Callers get their own copy of a cached address, so that changing it
+// doesn't change the cache. The keys are shared, so they must not be changed.
+func copyAddress(addr *identity.Address) *identity.Address {
+	if addr == nil || addr.IsPublic() {
+		return addr
+	}
+	out := *addr
+	out.Fingerprint = append([]byte(nil), addr.Fingerprint...)
+	return &out
+}
@@ -0,0 +1,205 @@
+package routing
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"airdispat.ch/identity"
+)
+
+// Counts the lookups that reach it
+type countingRouter struct {
+	known   map[string]*identity.Address
+	expires time.Time
+	down    bool
+	lookups int
+}
+
+func (r *countingRouter) Register(id *identity.Identity, alias string, redirects map[string]Redirect) error {
+	r.known[id.Address.String()] = id.Address
+	return nil
+}
+
+func (r *countingRouter) Lookup(addr string, name LookupType) (*identity.Address, error) {
+	found, _, err := r.LookupWithExpiry(addr, name)
+	return found, err
+}
+
+func (r *countingRouter) LookupAlias(alias string, name LookupType) (*identity.Address, error) {
+	return r.Lookup(alias, name)
+}
+
+func (r *countingRouter) LookupWithExpiry(addr string, name LookupType) (*identity.Address, time.Time, error) {
+	r.lookups++
+	if r.down {
+		return nil, time.Time{}, errors.New("Router is down.")
+	}
+
+	found, ok := r.known[addr]
+	if !ok {
+		return nil, time.Time{}, ErrAddressNotFound
+	}
+	return found, r.expires, nil
+}
+
+func (r *countingRouter) LookupAliasWithExpiry(alias string, name LookupType) (*identity.Address, time.Time, error) {
+	return r.LookupWithExpiry(alias, name)
+}
+
+func TestCachingRouter(t *testing.T) {
+	backing := &countingRouter{
+		known:   make(map[string]*identity.Address),
+		expires: time.Now().Add(time.Hour),
+	}
+	addrs := make([]string, 3)
+	for i := range addrs {
+		addr := identity.CreateAddressFromBytes([]byte{byte(i)})
+		addr.Location = "example.com:2048"
+		backing.known[addr.String()] = addr
+		addrs[i] = addr.String()
+	}
+
+	c := CreateCachingRouter(backing)
+	c.MaxEntries = 2
+
+	found, err := c.Lookup(addrs[0], LookupTypeDEFAULT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found.HasLocation() {
+		t.Error("Expected cached address to have a location.")
+	}
+	found.Location = "changed"
+	found.Fingerprint[0] ^= 1
+
+	found, err = c.Lookup(addrs[0], LookupTypeDEFAULT)
+	if err != nil || found.Location != "example.com:2048" || found.String() != addrs[0] || backing.lookups != 1 {
+		t.Error("Expected an unchanged address from the cache.", found, err, backing.lookups)
+	}
+
+	// Addresses that can't be found are cached as well.
+	for i := 0; i < 2; i++ {
+		if _, err := c.Lookup("missing", LookupTypeDEFAULT); err != ErrAddressNotFound {
+			t.Error("Expected ErrAddressNotFound, got", err)
+		}
+	}
+	if backing.lookups != 2 {
+		t.Error("Expected not found lookup to be cached, got", backing.lookups, "lookups")
+	}
+
+	// The least recently used lookup is forgotten once the cache is full.
+	c.Lookup(addrs[1], LookupTypeDEFAULT)
+	c.Lookup(addrs[1], LookupTypeDEFAULT)
+	if backing.lookups != 3 {
+		t.Error("Expected 3 lookups, got", backing.lookups)
+	}
+	c.Lookup(addrs[0], LookupTypeDEFAULT)
+	if backing.lookups != 4 {
+		t.Error("Expected evicted lookup to reach the router, got", backing.lookups)
+	}
+
+	c.Invalidate(addrs[0])
+	c.Lookup(addrs[0], LookupTypeDEFAULT)
+	if backing.lookups != 5 {
+		t.Error("Expected invalidated lookup to reach the router, got", backing.lookups)
+	}
+
+	// Lookups expire with their registration, and failures aren't cached.
+	c.Flush()
+	backing.expires = time.Now().Add(-time.Second)
+	c.Lookup(addrs[2], LookupTypeDEFAULT)
+	c.Lookup(addrs[2], LookupTypeDEFAULT)
+	if backing.lookups != 7 {
+		t.Error("Expected expired lookup to reach the router, got", backing.lookups)
+	}
+
+	backing.down = true
+	c.Lookup(addrs[2], LookupTypeMAIL)
+	c.Lookup(addrs[2], LookupTypeMAIL)
+	if backing.lookups != 9 {
+		t.Error("Expected failed lookup not to be cached, got", backing.lookups)
+	}
+}
+
+// Blocks every lookup until released
+type blockingRouter struct {
+	started chan bool
+	release chan bool
+	calls   int32
+}
+
+func (r *blockingRouter) Register(id *identity.Identity, alias string, redirects map[string]Redirect) error {
+	return nil
+}
+
+func (r *blockingRouter) Lookup(addr string, name LookupType) (*identity.Address, error) {
+	atomic.AddInt32(&r.calls, 1)
+	r.started <- true
+	<-r.release
+	return identity.CreateAddressFromBytes([]byte(addr)), nil
+}
+
+func (r *blockingRouter) LookupAlias(alias string, name LookupType) (*identity.Address, error) {
+	return r.Lookup(alias, name)
+}
+
+func TestCachingRouterInFlight(t *testing.T) {
+	backing := &blockingRouter{
+		started: make(chan bool, 10),
+		release: make(chan bool),
+	}
+	c := CreateCachingRouter(backing)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Lookup("address", LookupTypeDEFAULT); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	<-backing.started
+	time.Sleep(50 * time.Millisecond)
+	close(backing.release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&backing.calls); n != 1 {
+		t.Error("Expected concurrent lookups to share one lookup, got", n)
+	}
+}
+
+func TestCachingRouterInvalidateInFlight(t *testing.T) {
+	backing := &blockingRouter{
+		started: make(chan bool, 10),
+		release: make(chan bool),
+	}
+	c := CreateCachingRouter(backing)
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		if _, err := c.Lookup("address", LookupTypeDEFAULT); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// The registration changes while the lookup is running.
+	<-backing.started
+	c.Invalidate("address")
+	close(backing.release)
+	<-done
+
+	if _, err := c.Lookup("address", LookupTypeDEFAULT); err != nil {
+		t.Error(err)
+	}
+
+	if n := atomic.LoadInt32(&backing.calls); n != 2 {
+		t.Error("Expected a lookup from before Invalidate not to be cached, got", n, "calls")
+	}
+}
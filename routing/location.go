@@ -1,21 +1,31 @@
 package routing
 
-import "airdispat.ch/identity"
+import (
+	"errors"
 
+	"airdispat.ch/identity"
+)
+
+// Location is a Router that sends every address to the same server. It does
+// not know any keys, so it is only useful for reaching public messages.
 type Location string
 
-func (l Location) Register(*identity.Identity) error {
-	panic("Should not call Register on Location")
+func (l Location) Register(id *identity.Identity, alias string, redirects map[string]Redirect) error {
+	return errors.New("Can't register with a Location router.")
 }
 
-func (l Location) Lookup(addr string) (*identity.Address, error) {
-	return &identity.Address{
-		Location: string(l),
-	}, nil
+func (l Location) Lookup(addr string, name LookupType) (*identity.Address, error) {
+	a, err := identity.CreateAddressFromString(addr)
+	if err != nil {
+		return nil, err
+	}
+	a.Location = string(l)
+	return a, nil
 }
 
-func (l Location) LookupAlias(alias string) (*identity.Address, error) {
+func (l Location) LookupAlias(alias string, name LookupType) (*identity.Address, error) {
 	return &identity.Address{
 		Location: string(l),
+		Alias:    alias,
 	}, nil
 }
@@ -0,0 +1,59 @@
+package routing
+
+import (
+	"errors"
+
+	"airdispat.ch/identity"
+)
+
+// MaxRedirects is the most redirects that Resolve will follow for a lookup.
+var MaxRedirects = 8
+
+var ErrRedirectLoop = errors.New("Redirects for the address form a loop.")
+var ErrTooManyRedirects = errors.New("Address has too many redirects.")
+
+// RedirectLookup finds the address and redirects that a Redirect points to,
+// without following any redirects itself.
+type RedirectLookup func(r Redirect) (*identity.Address, map[string]Redirect, error)
+
+// Resolve follows the redirects of an address for a lookup of type name. At
+// each address, the redirect for name is followed if there is one, and the
+// redirect for LookupTypeDEFAULT otherwise. The address without a redirect
+// to follow is returned.
+//
+// Lookups of type LookupTypeDEFAULT don't follow any redirects, so that they
+// find the keys of the address itself (such as to reply to its owner).
+func Resolve(addr *identity.Address, redirects map[string]Redirect, name LookupType, lookup RedirectLookup) (*identity.Address, error) {
+	if name == LookupTypeDEFAULT {
+		return addr, nil
+	}
+
+	visited := map[string]bool{
+		addr.String(): true,
+	}
+
+	for hops := 0; ; hops++ {
+		redirect, ok := redirects[string(name)]
+		if !ok {
+			redirect, ok = redirects[string(LookupTypeDEFAULT)]
+		}
+		if !ok || (redirect.Fingerprint == "" && redirect.Alias == "") {
+			return addr, nil
+		}
+
+		if hops >= MaxRedirects {
+			return nil, ErrTooManyRedirects
+		}
+
+		var err error
+		addr, redirects, err = lookup(redirect)
+		if err != nil {
+			return nil, err
+		}
+
+		if visited[addr.String()] {
+			return nil, ErrRedirectLoop
+		}
+		visited[addr.String()] = true
+	}
+}
@@ -0,0 +1,96 @@
+package routing
+
+import (
+	"errors"
+	"testing"
+
+	"airdispat.ch/identity"
+)
+
+type testEntry struct {
+	addr      *identity.Address
+	redirects map[string]Redirect
+}
+
+// A directory of addresses by fingerprint and alias
+type testDirectory map[string]testEntry
+
+func (d testDirectory) add(name string, redirects ...Redirect) *identity.Address {
+	addr := identity.CreateAddressFromBytes([]byte(name))
+	addr.Alias = name
+
+	entry := testEntry{addr, make(map[string]Redirect)}
+	for _, v := range redirects {
+		entry.redirects[string(v.Type)] = v
+	}
+	d[addr.String()] = entry
+	d[name] = entry
+	return addr
+}
+
+func (d testDirectory) lookup(r Redirect) (*identity.Address, map[string]Redirect, error) {
+	key := r.Fingerprint
+	if key == "" {
+		key = r.Alias
+	}
+
+	entry, ok := d[key]
+	if !ok {
+		return nil, nil, errors.New("Unknown address.")
+	}
+	return entry.addr, entry.redirects, nil
+}
+
+func (d testDirectory) resolve(addr *identity.Address, name LookupType) (*identity.Address, error) {
+	entry := d[addr.String()]
+	return Resolve(entry.addr, entry.redirects, name, d.lookup)
+}
+
+func TestResolve(t *testing.T) {
+	d := make(testDirectory)
+
+	transfer := d.add("transfer")
+	alerts := d.add("alerts")
+	mail := d.add("mail", Redirect{Type: LookupTypeTX, Alias: "transfer"})
+	user := d.add("user",
+		Redirect{Type: LookupTypeMAIL, Fingerprint: mail.String()},
+		Redirect{Type: LookupTypeDEFAULT, Alias: "alerts"},
+	)
+
+	tests := []struct {
+		name     LookupType
+		expected *identity.Address
+	}{
+		{LookupTypeMAIL, mail},
+		{LookupTypeALERT, alerts},
+		{LookupTypeDEFAULT, user},
+	}
+	for _, v := range tests {
+		found, err := d.resolve(user, v.name)
+		if err != nil {
+			t.Error(v.name, err)
+		} else if found != v.expected {
+			t.Errorf("Lookup of type %s found %s, expected %s", v.name, found.Alias, v.expected.Alias)
+		}
+	}
+
+	// Redirects are followed for as long as there are more of them.
+	d.add("chain", Redirect{Type: LookupTypeTX, Fingerprint: mail.String()})
+	found, err := d.resolve(d["chain"].addr, LookupTypeTX)
+	if err != nil || found != transfer {
+		t.Error("Expected chain of redirects to end at transfer, got", found, err)
+	}
+
+	d.add("loopA", Redirect{Type: LookupTypeMAIL, Alias: "loopB"})
+	d.add("loopB", Redirect{Type: LookupTypeMAIL, Alias: "loopA"})
+	if _, err := d.resolve(d["loopA"].addr, LookupTypeMAIL); err != ErrRedirectLoop {
+		t.Error("Expected redirect loop, got", err)
+	}
+
+	old := MaxRedirects
+	MaxRedirects = 1
+	defer func() { MaxRedirects = old }()
+	if _, err := d.resolve(d["chain"].addr, LookupTypeTX); err != ErrTooManyRedirects {
+		t.Error("Expected too many redirects, got", err)
+	}
+}
@@ -3,6 +3,8 @@
 package routing
 
 import (
+	"errors"
+
 	"airdispat.ch/identity"
 )
 
@@ -39,3 +41,17 @@ type Redirect struct {
 	Fingerprint string
 	Alias       string
 }
+
+var ErrNoSuccessions = errors.New("Router doesn't store successions.")
+
+// SuccessionRouter is a Router that also stores the records that hand an
+// address over to a new key (see message.Succession). The records are passed
+// around signed by the old key, so that the router doesn't have to be
+// trusted.
+type SuccessionRouter interface {
+	Router
+	// RegisterSuccession stores a signed succession with the router.
+	RegisterSuccession(signed []byte) error
+	// LookupSuccession returns the signed succession of an address.
+	LookupSuccession(addr string) ([]byte, error)
+}
@@ -12,7 +12,7 @@ import (
 
 func createHeader(from *identity.Address, to ...*identity.Address) message.Header {
 	header := message.CreateHeader(from, to...)
-	header.EncryptionKey = crypto.RSAToBytes(from.EncryptionKey)
+	header.EncryptionKey = crypto.EncryptionKeyToBytes(from.EncryptionKey)
 	return header
 }
 
@@ -116,8 +116,13 @@ func CreateTransferMessageFromBytes(by []byte, h message.Header) (*TransferMessa
 		return nil, err
 	}
 
+	author, err := identity.CreateAddressFromString(fromData.GetAuthor())
+	if err != nil {
+		return nil, err
+	}
+
 	return &TransferMessage{
-		Author: identity.CreateAddressFromString(fromData.GetAuthor()),
+		Author: author,
 		Name:   fromData.GetName(),
 		Data:   fromData.GetData(),
 		h:      h,
@@ -198,8 +203,13 @@ func CreateTransferMessageListFromBytes(by []byte, h message.Header) (*TransferM
 		return nil, err
 	}
 
+	author, err := identity.CreateAddressFromString(fromData.GetAuthor())
+	if err != nil {
+		return nil, err
+	}
+
 	return &TransferMessageList{
-		Author: identity.CreateAddressFromString(fromData.GetAuthor()),
+		Author: author,
 		Since:  fromData.GetLastUpdated(),
 		h:      h,
 	}, nil
@@ -225,3 +235,53 @@ func (m *TransferMessageList) Type() string {
 func (m *TransferMessageList) Header() message.Header {
 	return m.h
 }
+
+// --- Sessions ---
+
+// SessionVersion is the version of the session protocol spoken by this
+// package.
+const SessionVersion uint32 = 1
+
+type SessionMessage struct {
+	Version uint32
+	h       message.Header
+}
+
+func CreateSessionMessage(from *identity.Address, to *identity.Address) *SessionMessage {
+	return &SessionMessage{
+		Version: SessionVersion,
+		h:       createHeader(from, to),
+	}
+}
+
+func CreateSessionMessageFromBytes(by []byte, h message.Header) (*SessionMessage, error) {
+	fromData := &wire.Session{}
+	err := proto.Unmarshal(by, fromData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionMessage{
+		Version: fromData.GetVersion(),
+		h:       h,
+	}, nil
+}
+
+func (m *SessionMessage) ToBytes() []byte {
+	toData := &wire.Session{
+		Version: &m.Version,
+	}
+	by, err := proto.Marshal(toData)
+	if err != nil {
+		panic("Can't marshal Session.")
+	}
+	return by
+}
+
+func (m *SessionMessage) Type() string {
+	return wire.SessionCode
+}
+
+func (m *SessionMessage) Header() message.Header {
+	return m.h
+}
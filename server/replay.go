@@ -0,0 +1,74 @@
+package server
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+
+	"airdispat.ch/message"
+)
+
+// Returned by replayCache.check for a message that has been seen before.
+var errReplayedMessage = errors.New("Received a message that may have already been handled.")
+
+// replayCache remembers the signed messages that a server has accepted, so
+// that a captured message can't be accepted twice. Messages only need to be
+// remembered until their timestamp falls outside of message.TimestampWindow,
+// as ReconstructMessageWithTimestamp rejects them after that.
+//
+// The cache is only kept in memory, so messages from before the server
+// started are refused, as they may have been accepted before a restart.
+// Clients whose clocks are behind may see their messages refused for up to
+// message.TimestampWindow after a restart.
+type replayCache struct {
+	lock    sync.Mutex
+	seen    map[[sha256.Size]byte]int64
+	swept   int64
+	started int64
+}
+
+// Refuses messages from before t, unless the cache was already started.
+func (r *replayCache) start(t time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.started == 0 {
+		r.started = t.Unix()
+	}
+}
+
+// Records a signed message. It returns errReplayedMessage if the message has
+// been seen before, or a *message.TimestampError if it is from before the
+// cache was started.
+func (r *replayCache) check(signed *message.SignedMessage, h message.Header) error {
+	hash := sha256.Sum256(signed.Data)
+	now := time.Now().Unix()
+	window := int64(message.TimestampWindow / time.Second)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if h.Timestamp < r.started {
+		return &message.TimestampError{Now: now, Timestamp: h.Timestamp}
+	} else if r.seen == nil {
+		r.seen = make(map[[sha256.Size]byte]int64)
+	}
+
+	// Forget messages that would now fail the timestamp check
+	if now-r.swept >= window {
+		for k, expires := range r.seen {
+			if expires < now {
+				delete(r.seen, k)
+			}
+		}
+		r.swept = now
+	}
+
+	if expires, ok := r.seen[hash]; ok && expires >= now {
+		return errReplayedMessage
+	}
+
+	r.seen[hash] = h.Timestamp + window
+	return nil
+}
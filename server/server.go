@@ -1,12 +1,15 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync"
 	"time"
 
+	"airdispat.ch/crypto"
 	adErrors "airdispat.ch/errors"
 	"airdispat.ch/identity"
 	"airdispat.ch/message"
@@ -26,40 +29,107 @@ type ServerDelegate interface {
 	HandleError(err *ServerError)
 	LogMessage(toLog ...string)
 
-	SaveMessageDescription(desc *message.EncryptedMessage)
+	// Called once for each recipient of an alert, with only the key
+	// information for that recipient. Public alerts are sent once, for
+	// identity.Public.
+	SaveMessageDescription(desc *message.EncryptedMessage, forAddr *identity.Address)
 
 	RetrieveDataForUser(id string, author *identity.Address, forAddr *identity.Address) (*message.EncryptedMessage, io.ReadCloser)
 	RetrieveMessageForUser(id string, author *identity.Address, forAddr *identity.Address) *message.EncryptedMessage
 	RetrieveMessageListForUser(since uint64, author *identity.Address, forAddr *identity.Address) []*message.EncryptedMessage
 }
 
+// A ServerDelegate may also implement LocalUserDelegate to only receive
+// alerts for the users that it hosts.
+type LocalUserDelegate interface {
+	IsLocalUser(addr *identity.Address) bool
+}
+
 // The server structure tahat holds all of the necessary instance variables
+//
+// Replayed messages are only remembered in memory, so a server refuses
+// messages with timestamps from before it started serving.
 type Server struct {
 	LocationName string
 	Key          *identity.Identity
 	Delegate     ServerDelegate
 	Handlers     []Handler
 	Router       routing.Router
+	// The transport to listen with. If nil, message.DefaultTransport is used.
+	Transport message.Transport
+	// The largest message (in bytes) that will be read from a client. If
+	// zero, wire.DefaultMaxFrameSize is used.
+	MaxFrameSize int32
+	// How long the server will wait for each message from a client, and for
+	// each write to a client. Zero means no deadline.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// How many requests in a session are handled at once. No more frames are
+	// read from the session until one of them finishes. If zero,
+	// DefaultMaxSessionRequests is used.
+	MaxSessionRequests int
 	// Control Channels
 	Start chan bool
 	Quit  chan bool
+
+	lock      sync.Mutex
+	closed    bool
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	sessions  map[*serverSession]bool
+	inFlight  sync.WaitGroup
+	replays   replayCache
+}
+
+// Returned by Serve and StartServer once Shutdown has been called.
+var ErrServerClosed = errors.New("Server has been shut down.")
+
+// Returned by Shutdown if clients were still being handled when its context
+// ended. Their connections are closed.
+type ShutdownError struct {
+	Remaining int
+	Err       error
 }
 
-// Function that starts the server on a specific port
+func (s *ShutdownError) Error() string {
+	return fmt.Sprintf("Shutdown interrupted with %d clients remaining: %v", s.Remaining, s.Err)
+}
+
+// Function that starts the server on a specific port. Sending on the Quit
+// channel stops the server from accepting new clients.
 func (s *Server) StartServer(port string) error {
-	// Resolve the Address of the Server
 	service := ":" + port
-	tcpAddr, _ := net.ResolveTCPAddr("tcp4", service)
 	s.Delegate.LogMessage("Starting Server on " + service)
 
+	transport := s.Transport
+	if transport == nil {
+		transport = message.DefaultTransport
+	}
+
 	// Start the Server
-	listener, err := net.ListenTCP("tcp", tcpAddr)
+	listener, err := transport.Listen(service)
 	if err != nil {
 		return err
 	}
 
-	s.serverLoop(listener)
-	return nil
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if s.Quit != nil {
+		go func() {
+			select {
+			case <-s.Quit:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	err = s.Serve(ctx, listener)
+	if err == context.Canceled {
+		return nil
+	}
+	return err
 }
 
 // Sends an error to the Server Delegate
@@ -70,26 +140,26 @@ func (s *Server) handleError(location string, error error) {
 	})
 }
 
-// The loop that continues while waiting on clients to connect
-func (s *Server) serverLoop(listener *net.TCPListener) {
-	connections := make(chan net.Conn)
+// Serve accepts clients on listener until ctx is done or Shutdown is called.
+// The listener is closed when Serve returns. Clients that are still being
+// handled are left alone; use Shutdown to wait for them.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	if !s.trackListener(listener, true) {
+		listener.Close()
+		return ErrServerClosed
+	}
+	defer s.trackListener(listener, false)
+	defer listener.Close()
+	s.replays.start(time.Now())
 
-	// Loop forever, waiting for connections
+	// Stop accepting once the context is done
+	stop := make(chan struct{})
+	defer close(stop)
 	go func() {
-		for {
-			// Accept a Connection
-			conn, err := listener.Accept()
-			if err != nil {
-				// Shutdown if the Error is Quit-Related
-				select {
-				case <-s.Quit:
-					return
-				default:
-					s.handleError("Server Loop (Accepting New Client)", err)
-					continue
-				}
-			}
-			connections <- conn
+		select {
+		case <-ctx.Done():
+			listener.Close()
+		case <-stop:
 		}
 	}()
 
@@ -98,37 +168,182 @@ func (s *Server) serverLoop(listener *net.TCPListener) {
 	}
 
 	for {
-		select {
-		case conn := <-connections:
-			// Concurrently handle the connection
-			go s.handleClient(conn)
-		case <-s.Quit:
-			// Close the listener
-			close(s.Quit)
-			listener.Close()
-			return
+		// Accept a Connection
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			} else if ctx.Err() != nil {
+				return ctx.Err()
+			} else if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			s.handleError("Server Loop (Accepting New Client)", err)
+			continue
+		}
+
+		if !s.trackConn(conn, true) {
+			conn.Close()
+			return ErrServerClosed
+		}
+
+		// Concurrently handle the connection
+		go func() {
+			defer s.inFlight.Done()
+			defer s.trackConn(conn, false)
+			s.handleClient(conn)
+		}()
+	}
+}
+
+// Shutdown stops every call to Serve from accepting clients, then waits for
+// the clients being handled to finish. Sessions are closed once they have no
+// requests left. If ctx ends first, their connections are closed and a
+// *ShutdownError is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	// Idle sessions would wait for another request forever. The others are
+	// closed once their last request has been answered.
+	for v := range s.sessions {
+		if v.requests == 0 {
+			v.conn.Close()
 		}
 	}
+	s.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.lock.Lock()
+	remaining := len(s.conns)
+	for c := range s.conns {
+		c.Close()
+	}
+	s.lock.Unlock()
+
+	return &ShutdownError{
+		Remaining: remaining,
+		Err:       ctx.Err(),
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.closed
+}
+
+// Adds or removes a listener, refusing to add one after Shutdown
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !add {
+		delete(s.listeners, l)
+		return true
+	}
+
+	if s.closed {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]bool)
+	}
+	s.listeners[l] = true
+	return true
+}
+
+// Adds or removes a client connection, refusing to add one after Shutdown.
+// Adding a connection also counts it as in flight.
+func (s *Server) trackConn(conn net.Conn, add bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !add {
+		delete(s.conns, conn)
+		return true
+	}
+
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]bool)
+	}
+	s.conns[conn] = true
+	s.inFlight.Add(1)
+	return true
+}
+
+// Creates a FrameReader for a client connection that respects the
+// server's MaxFrameSize and ReadTimeout
+func (s *Server) frameReader(conn net.Conn) *wire.FrameReader {
+	reader := wire.NewFrameReader(conn)
+	if s.MaxFrameSize != 0 {
+		reader.MaxFrameSize = s.MaxFrameSize
+	}
+	reader.Timeout = s.ReadTimeout
+	return reader
+}
+
+// deadlineConn sets a fresh write deadline before every write to a client.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	c.Conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
 }
 
 // Called when a client connects
 func (s *Server) handleClient(conn net.Conn) {
 	s.Delegate.LogMessage("Serving", conn.RemoteAddr().String())
 	tNow := time.Now()
-	defer s.Delegate.LogMessage("Finished with", conn.RemoteAddr().String(), "in", time.Since(tNow).String())
+	defer func() {
+		s.Delegate.LogMessage("Finished with", conn.RemoteAddr().String(), "in", time.Since(tNow).String())
+	}()
 
 	// Close the Connection after Handling
 	defer conn.Close()
 
+	if s.WriteTimeout != 0 {
+		conn = &deadlineConn{conn, s.WriteTimeout}
+	}
+
 	// Read in the Message
-	newMessage, err := message.ReadMessageFromConnection(conn)
+	newMessage, err := message.ReadMessageFromFrameReader(s.frameReader(conn))
 	if err != nil {
 		// There is nothing we can do if we can't read the message.
 		s.handleError("Read Message From Connection", err)
+		if _, ok := err.(*wire.FrameSizeError); ok {
+			adErrors.CreateError(adErrors.UnexpectedError, "Message is too large.", s.Key.Address).Send(s.Key, conn)
+			return
+		}
 		adErrors.CreateError(adErrors.UnexpectedError, "Unable to read message properly.", s.Key.Address).Send(s.Key, conn)
 		return
 	}
 
+	s.handleMessage(newMessage, conn, false)
+}
+
+// Handles a single message from a client, writing any responses to conn.
+// Messages received inside of a session may not open another session.
+func (s *Server) handleMessage(newMessage *message.EncryptedMessage, conn net.Conn, inSession bool) {
 	_, ok := newMessage.Header[s.Key.Address.String()]
 	if ok {
 		signedMessage, err := newMessage.Decrypt(s.Key)
@@ -146,18 +361,41 @@ func (s *Server) handleClient(conn net.Conn) {
 
 		data, mesType, h, err := signedMessage.ReconstructMessageWithTimestamp()
 
-		if err != nil {
+		if _, ok := err.(*message.TimestampError); ok {
+			s.handleError("Verifying Message Timestamp", err)
+			adErrors.CreateError(adErrors.InvalidTimestamp, "Message timestamp is too old or too new.", s.Key.Address).Send(s.Key, conn)
+			return
+		} else if err != nil {
 			s.handleError("Verifying Message Structure", err)
 			adErrors.CreateError(adErrors.UnexpectedError, "Unable to unpack transfer message.", s.Key.Address).Send(s.Key, conn)
 			return
 		}
 
+		if err := s.replays.check(signedMessage, h); err == errReplayedMessage {
+			s.handleError("Checking for Replays", err)
+			adErrors.CreateError(adErrors.ReplayedMessage, "Message has already been received.", s.Key.Address).Send(s.Key, conn)
+			return
+		} else if err != nil {
+			s.handleError("Checking for Replays", err)
+			adErrors.CreateError(adErrors.InvalidTimestamp, "Message timestamp is too old or too new.", s.Key.Address).Send(s.Key, conn)
+			return
+		}
+
 		// Switch based on the Message Type
 		switch mesType {
 		case wire.TransferMessageCode:
 			s.handleTransferMessage(data, h, conn)
+			return
 		case wire.TransferMessageListCode:
 			s.handleTransferMessageList(data, h, conn)
+			return
+		case wire.SessionCode:
+			if inSession {
+				adErrors.CreateError(adErrors.UnexpectedError, "Cannot open a session inside of a session.", s.Key.Address).Send(s.Key, conn)
+				return
+			}
+			s.handleSession(data, h, conn)
+			return
 		}
 
 		returnAddress := h.From
@@ -218,9 +456,31 @@ func (s *Server) handleClient(conn net.Conn) {
 	}
 }
 
-// Send the Message to the Delegate
+// Split the Message into one Message for each local recipient, and send
+// each of them to the Delegate. Alerts without any recipients are public,
+// and are sent to the Delegate for identity.Public.
 func (s *Server) handleMessageDescription(desc *message.EncryptedMessage) {
-	s.Delegate.SaveMessageDescription(desc)
+	if len(desc.Header) == 0 {
+		s.Delegate.SaveMessageDescription(desc, identity.Public)
+		return
+	}
+
+	local, _ := s.Delegate.(LocalUserDelegate)
+	for key, header := range desc.Header {
+		if !crypto.VerifyStringAddress(header.To.String()) {
+			s.handleError("Saving Message Description", errors.New("Alert has a recipient with an invalid address."))
+			continue
+		} else if local != nil && !local.IsLocalUser(header.To) {
+			continue
+		}
+
+		s.Delegate.SaveMessageDescription(&message.EncryptedMessage{
+			Data: desc.Data,
+			Header: map[string]message.EncryptionHeader{
+				key: header,
+			},
+		}, header.To)
+	}
 }
 
 // Function that Handles a DataRetrieval Message
@@ -4,11 +4,13 @@ import (
 	"airdispat.ch/identity"
 	"airdispat.ch/message"
 	"airdispat.ch/server"
+	"context"
 	"flag"
-	"math/rand"
 	"net"
 	"os"
-	"strconv"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -17,6 +19,13 @@ var port = flag.String("port", "2048", "select the port on which to run the mail
 
 var me = flag.String("me", getServerLocation(), "the location of the server that it should broadcast to the world")
 var key_file = flag.String("key", "", "the file to store keys")
+var use_tls = flag.Bool("tls", false, "accept connections over TLS with a certificate for the server key")
+var data_dir = flag.String("data", "mail", "the directory in which to store mail")
+var key_passphrase = flag.String("passphrase-env", "AIRDISPATCH_KEY_PASSPHRASE", "the environment variable holding the passphrase used to encrypt the key file")
+var shutdown_timeout = flag.Duration("shutdown-timeout", 30*time.Second, "how long to wait for clients to finish when shutting down")
+var read_timeout = flag.Duration("read-timeout", time.Minute, "how long to wait for each message from a client")
+var write_timeout = flag.Duration("write-timeout", time.Minute, "how long to wait for each write to a client")
+var local_users = flag.String("users", "", "comma separated addresses of the users hosted by the server, whose alerts are kept")
 
 func getServerLocation() string {
 	s, _ := os.Hostname()
@@ -24,64 +33,46 @@ func getServerLocation() string {
 	return ips[0] + ":" + *port
 }
 
-// Postoffice Stores Many User's Mailboxes
-type PostOffice map[string]*Mailbox
-
-func (p PostOffice) StoreOutgoingMessageForUser(user string, m *message.EncryptedMessage) ServerMail {
-	box, ok := p[user]
-	if !ok {
-		box = &Mailbox{
-			Incoming: make([]*message.EncryptedMessage, 0),
-			Outgoing: make(map[string]ServerMail),
-		}
-	}
-
-	s := ServerMail{
-		Mail:     m,
-		Name:     strconv.Itoa(rand.Int()),
-		SentTime: time.Now(),
-	}
-	box.Outgoing[s.Name] = s
-	p[user] = box
-	return s
-}
-
-type Mailbox struct {
-	Incoming []*message.EncryptedMessage
-	Outgoing map[string]ServerMail
-	Public   []ServerMail
-	Identity *identity.Identity
-}
-
-type ServerMail struct {
-	Mail     *message.EncryptedMessage
-	Name     string
-	SentTime time.Time
-}
-
-// Set up the Mailboxes of Users (to store incoming mail)
-var mailboxes PostOffice
-
-// Set up the outgoing messages boxes
-var storedMessages Mailbox
-
 // Variables that store information about the server
 var connectedTrackers []string
 var serverLocation string
 var serverKey *identity.Identity
 
 func main() {
-	rand.Seed(time.Now().Unix())
 	// Parse the configuration Command Line Falgs
 	flag.Parse()
 
-	// Initialize Incoming and Outgoing Mailboxes
-	mailboxes = make(PostOffice)
+	// Open the Store for Incoming and Outgoing Mailboxes
+	handler, err := server.OpenFileStore(*data_dir)
+	if err != nil {
+		server.BasicServer{}.HandleError(&server.ServerError{"Opening Mail Store", err})
+		return
+	}
+	defer handler.Close()
+
+	// Add the Users that Alerts are Kept For
+	for _, v := range strings.Split(*local_users, ",") {
+		if v == "" {
+			continue
+		}
+
+		addr, err := identity.CreateAddressFromString(v)
+		if err != nil {
+			handler.HandleError(&server.ServerError{"Adding Local User", err})
+			return
+		}
+
+		err = handler.AddLocalUser(addr)
+		if err != nil {
+			handler.HandleError(&server.ServerError{"Adding Local User", err})
+			return
+		}
+	}
 
 	// Create a Signing Key for the Server
-	handler := &myServer{}
-	loadedKey, err := identity.LoadKeyFromFile(*key_file)
-	if err != nil {
+	passphrase := os.Getenv(*key_passphrase)
+	loadedKey, err := loadServerKey(*key_file, passphrase)
+	if os.IsNotExist(err) {
 
 		loadedKey, err = identity.CreateIdentity()
 		if err != nil {
@@ -90,94 +81,88 @@ func main() {
 		}
 
 		if *key_file != "" {
-			err = loadedKey.SaveKeyToFile(*key_file)
+			err = saveServerKey(loadedKey, *key_file, passphrase)
 			if err != nil {
 				handler.HandleError(&server.ServerError{"Saving Mailserver Key", err})
 				return
 			}
 		}
 
+	} else if err != nil {
+		handler.HandleError(&server.ServerError{"Loading Mailserver Key", err})
+		return
 	}
 	serverKey = loadedKey
 	handler.LogMessage("Loaded Address", loadedKey.Address.String())
 
 	// Find the location of this server
 	serverLocation = *me
-	theServer := server.Server{
+	theServer := &server.Server{
 		LocationName: *me,
 		Key:          serverKey,
 		Delegate:     handler,
+		ReadTimeout:  *read_timeout,
+		WriteTimeout: *write_timeout,
+	}
+
+	if *use_tls {
+		transport, err := message.CreateTLSTransport(serverKey)
+		if err != nil {
+			handler.HandleError(&server.ServerError{"Creating TLS Certificate", err})
+			return
+		}
+		theServer.Transport = transport
 	}
 
 	StartServer(theServer, handler)
 }
 
-func StartServer(theServer server.Server, handler *myServer) {
-	err := theServer.StartServer(*port)
-	if err != nil {
-		handler.HandleError(&server.ServerError{"Saving Mailserver Key", err})
+// Loads the server key, encrypting legacy key files in place if a
+// passphrase has been provided.
+func loadServerKey(filename string, passphrase string) (*identity.Identity, error) {
+	if passphrase == "" {
+		return identity.LoadKeyFromFile(filename)
 	}
-	os.Exit(1)
-}
-
-type myServer struct {
-	server.BasicServer
-}
 
-// Function that Handles an Alert of a Message
-// INCOMING
-func (myServer) SaveMessageDescription(desc *message.EncryptedMessage) {
-	// Get the recipient address of the message
-	toAddr := desc.To
-
-	// Attempt to Get the Mailbox of the User
-	v, ok := mailboxes[toAddr.String()]
-	if !ok {
-		// TODO: Catch if the user is registered with the server or not
-		// If it cannot, make a mailbox
-		v = &Mailbox{
-			Incoming: make([]*message.EncryptedMessage, 0),
-			Outgoing: make(map[string]ServerMail),
-		}
+	err := identity.MigrateKeyFile(filename, passphrase)
+	if err != nil {
+		return nil, err
 	}
 
-	// Store the Record in the User's Mailbox
-	v.Incoming = append(v.Incoming, desc)
-	mailboxes[toAddr.String()] = v
+	return identity.LoadKeyFromFileWithPassphrase(filename, passphrase)
 }
 
-func (myServer) RetrieveMessageForUser(name string, author *identity.Address, forAddr *identity.Address) *message.EncryptedMessage {
-	box, ok := mailboxes[author.String()]
-	if !ok {
-		return nil
+func saveServerKey(key *identity.Identity, filename string, passphrase string) error {
+	if passphrase == "" {
+		return key.SaveKeyToFile(filename)
 	}
+	return key.SaveKeyToFileWithPassphrase(filename, passphrase)
+}
 
-	mail, ok := box.Outgoing[name]
-	if !ok {
-		return nil
-	}
+func StartServer(theServer *server.Server, handler *server.FileStore) {
+	// Shutdown gracefully when interrupted
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 
-	return mail.Mail
-}
+	shutdown := make(chan error, 1)
+	go func() {
+		<-interrupt
+		handler.LogMessage("Shutting down Mailserver")
 
-func (m myServer) RetrieveMessageListForUser(since uint64, author *identity.Address, forAddr *identity.Address) []*message.EncryptedMessage {
-	// Get the `TimeSince` field
-	timeSince := time.Unix(int64(since), 0)
-	output := make([]*message.EncryptedMessage, 0)
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdown_timeout)
+		defer cancel()
+		shutdown <- theServer.Shutdown(ctx)
+	}()
 
-	// Get the public notices box for that address
-	box, ok := mailboxes[author.String()]
-	if !ok {
-		// If it does not exist, return nothing
-		return nil
+	err := theServer.StartServer(*port)
+	if err != server.ErrServerClosed {
+		handler.HandleError(&server.ServerError{"Starting Mailserver", err})
+		handler.Close()
+		os.Exit(1)
 	}
 
-	// Loop through the messages
-	for _, v := range box.Public {
-		// Append the notice to the output if it was sent after the 'TimeSince'
-		if v.SentTime.After(timeSince) {
-			output = append(output, v.Mail)
-		}
+	err = <-shutdown
+	if err != nil {
+		handler.HandleError(&server.ServerError{"Shutting down Mailserver", err})
 	}
-	return output
 }
@@ -1,13 +1,18 @@
 package server
 
 import (
+	adErrors "airdispat.ch/errors"
 	"airdispat.ch/identity"
 	"airdispat.ch/message"
 	"airdispat.ch/routing"
 	adTest "airdispat.ch/testing"
 	"airdispat.ch/wire"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"testing"
 	"time"
 )
@@ -78,7 +83,7 @@ func (t TestSendMessageDelegate) HandleError(err *ServerError) {
 	t.Errors <- errors.New(fmt.Sprintf("%s at %s", err.Error, err.Location))
 }
 
-func (t TestSendMessageDelegate) SaveMessageDescription(m *message.EncryptedMessage) {
+func (t TestSendMessageDelegate) SaveMessageDescription(m *message.EncryptedMessage, forAddr *identity.Address) {
 	message, err := m.Decrypt(t.Decryption)
 	if err != nil {
 		t.Errors <- err
@@ -145,7 +150,7 @@ func TestTransferMessage(t *testing.T) {
 
 	<-started
 
-	msgDescription := CreateTransferMessage("testMessage", scene.Sender.Address, scene.Server.Address)
+	msgDescription := CreateTransferMessage("testMessage", scene.Sender.Address, scene.Server.Address, scene.Receiver.Address)
 
 	_, typ, _, err := message.SendMessageAndReceiveWithTimestamp(msgDescription, scene.Sender, scene.Server.Address)
 
@@ -176,7 +181,7 @@ type TestTransferMessageDelegate struct {
 func (t TestTransferMessageDelegate) HandleError(err *ServerError) {
 	t.Errors <- errors.New(fmt.Sprintf("%s at %s", err.Error, err.Location))
 }
-func (t TestTransferMessageDelegate) SaveMessageDescription(m *message.EncryptedMessage) {
+func (t TestTransferMessageDelegate) SaveMessageDescription(m *message.EncryptedMessage, forAddr *identity.Address) {
 	t.Errors <- errors.New("Wrong Function - Save Message Description")
 }
 func (t TestTransferMessageDelegate) RetrieveMessageForUser(id string, author *identity.Address, forAddr *identity.Address) *message.EncryptedMessage {
@@ -185,8 +190,8 @@ func (t TestTransferMessageDelegate) RetrieveMessageForUser(id string, author *i
 		return nil
 	}
 
-	mail := message.CreateMail(author, forAddr, time.Now())
-	cmps := make(message.ComponentList)
+	mail := message.CreateMail(author, time.Now(), "", forAddr)
+	cmps := make(message.ComponentList, 0)
 	cmps.AddComponent(
 		message.Component{
 			Name: "test",
@@ -218,3 +223,628 @@ func (t TestTransferMessageDelegate) RetrieveMessageListForUser(since uint64, au
 func TestPublicMessage(t *testing.T) {
 
 }
+
+// Test 3: Many Requests in one Session
+
+func TestSession(t *testing.T) {
+	fmt.Println("--- Starting Session Test")
+
+	store, err := OpenFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	started, quit, scene := testingSetup(t, store)
+	defer func() { quit <- true }()
+
+	<-started
+
+	mail := message.CreateMail(scene.Sender.Address, time.Now(), "", scene.Receiver.Address)
+	mail.Components.AddComponent(message.CreateStringComponent("test", "hello world"))
+
+	signed, err := message.SignMessage(mail, scene.Sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := signed.EncryptWithKey(scene.Receiver.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := store.StoreOutgoingMessage(scene.Sender.Address, enc, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := OpenSession(scene.Receiver, scene.Server.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	results := make(chan error, 5)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			transfer := CreateTransferMessage(stored.Name, scene.Receiver.Address, scene.Server.Address, scene.Sender.Address)
+			_, typ, _, err := session.SendMessageAndReceive(transfer)
+			if err == nil && typ != wire.MailCode {
+				err = errors.New("Wrong message type " + typ)
+			}
+			results <- err
+		}()
+	}
+
+	for i := 0; i < cap(results); i++ {
+		if err := <-results; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// Test 4: Shutdown
+func TestShutdown(t *testing.T) {
+	fmt.Println("--- Starting Shutdown Test")
+
+	scene, err := adTest.CreateScenario()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Starts a server and opens a session to it, which keeps a client in flight
+	serveWithSession := func(delegate ServerDelegate) (*Server, chan error, *Session) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		theServer := &Server{
+			Key:      scene.Server,
+			Delegate: delegate,
+		}
+
+		served := make(chan error, 1)
+		go func() {
+			served <- theServer.Serve(context.Background(), listener)
+		}()
+
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		session, err := OpenSessionOnConnection(scene.Sender, scene.Server.Address, conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return theServer, served, session
+	}
+
+	// Idle sessions are closed right away.
+	theServer, served, session := serveWithSession(BasicServer{})
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := theServer.Shutdown(ctx); err != nil {
+		t.Error("Expected clean shutdown with an idle session, got", err)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Error("Expected Serve to return ErrServerClosed, got", err)
+	}
+
+	// Requests that finish before the deadline are drained.
+	delegate := TestSessionLimitDelegate{
+		Running: make(chan struct{}, 1),
+		Release: make(chan struct{}),
+	}
+	request := func(session *Session) chan error {
+		done := make(chan error, 1)
+		go func() {
+			transfer := CreateTransferMessage("missing", scene.Sender.Address, scene.Server.Address, scene.Receiver.Address)
+			_, _, _, err := session.SendMessageAndReceive(transfer)
+			done <- err
+		}()
+		<-delegate.Running
+		return done
+	}
+
+	theServer, served, session = serveWithSession(delegate)
+	defer session.Close()
+	done := request(session)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- theServer.Shutdown(ctx)
+	}()
+
+	if err := <-served; err != ErrServerClosed {
+		t.Error("Expected Serve to return ErrServerClosed, got", err)
+	}
+	delegate.Release <- struct{}{}
+	if err := <-done; err != nil {
+		t.Error("Expected the request to be answered, got", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Error("Expected clean shutdown, got", err)
+	}
+
+	// Requests that are still running at the deadline are cut off.
+	theServer, served, session = serveWithSession(delegate)
+	defer session.Close()
+	request(session)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = theServer.Shutdown(ctx)
+	if e, ok := err.(*ShutdownError); !ok || e.Remaining != 1 {
+		t.Error("Expected ShutdownError with one client remaining, got", err)
+	}
+	<-served
+	close(delegate.Release)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := theServer.Serve(context.Background(), listener); err != ErrServerClosed {
+		t.Error("Expected Serve after Shutdown to fail, got", err)
+	}
+}
+
+// Test 5: Replayed and Stale Messages
+func TestReplay(t *testing.T) {
+	fmt.Println("--- Starting Replay Test")
+
+	scene, err := adTest.CreateScenario()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go (&Server{
+		Key:      scene.Server,
+		Delegate: store,
+	}).Serve(ctx, listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := OpenSessionOnConnection(scene.Sender, scene.Server.Address, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	// Sends enc and returns the error code the server replied with
+	errorCode := func(enc *message.EncryptedMessage) adErrors.Code {
+		r, err := session.RequestEncrypted(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reply, err := message.ReadMessageFromFrameReader(wire.NewFrameReader(r))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, typ, h, err := reply.Reconstruct(scene.Sender, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != wire.ErrorCode {
+			return 0
+		}
+		return adErrors.Code(adErrors.CreateErrorFromBytes(data, h).Code)
+	}
+
+	encrypt := func(m message.Message) *message.EncryptedMessage {
+		signed, err := message.SignMessage(m, scene.Sender)
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc, err := signed.EncryptWithKey(scene.Server.Address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return enc
+	}
+
+	enc := encrypt(CreateTransferMessage("missing", scene.Sender.Address, scene.Server.Address, scene.Receiver.Address))
+	if code := errorCode(enc); code != adErrors.MessageNotFound {
+		t.Error("Expected the first request to be handled, got code", code)
+	}
+	if code := errorCode(enc); code != adErrors.ReplayedMessage {
+		t.Error("Expected the replayed request to be rejected, got code", code)
+	}
+
+	stale := CreateTransferMessage("missing", scene.Sender.Address, scene.Server.Address, scene.Receiver.Address)
+	stale.h.Timestamp -= int64(2 * message.TimestampWindow / time.Second)
+	if code := errorCode(encrypt(stale)); code != adErrors.InvalidTimestamp {
+		t.Error("Expected the stale request to be rejected, got code", code)
+	}
+
+	// A message from before the server started may have been handled before
+	// a restart.
+	early := CreateTransferMessage("missing", scene.Sender.Address, scene.Server.Address, scene.Receiver.Address)
+	early.h.Timestamp -= 60
+	if code := errorCode(encrypt(early)); code != adErrors.InvalidTimestamp {
+		t.Error("Expected the request from before the server started to be rejected, got code", code)
+	}
+}
+
+// Test 6: Splitting Alerts by Recipient
+type TestSplitDelegate struct {
+	BasicServer
+	Local  map[string]bool
+	Alerts chan *message.EncryptedMessage
+}
+
+func (t TestSplitDelegate) IsLocalUser(addr *identity.Address) bool {
+	return t.Local[addr.String()]
+}
+
+func (t TestSplitDelegate) SaveMessageDescription(m *message.EncryptedMessage, forAddr *identity.Address) {
+	if forAddr.IsPublic() {
+		if len(m.Header) != 0 {
+			m = nil
+		}
+	} else if _, ok := m.Header[forAddr.String()]; !ok || len(m.Header) != 1 {
+		m = nil
+	}
+	t.Alerts <- m
+}
+
+func TestSplitAlert(t *testing.T) {
+	fmt.Println("--- Starting Split Alert Test")
+
+	scene, err := adTest.CreateScenario()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var to []*identity.Address
+	local := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		id, err := identity.CreateIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		id.Address.Location = listener.Addr().String()
+		to = append(to, id.Address)
+		local[id.Address.String()] = i != 2
+	}
+
+	delegate := TestSplitDelegate{
+		Local:  local,
+		Alerts: make(chan *message.EncryptedMessage, 3),
+	}
+
+	theServer := &Server{
+		Key:      scene.Server,
+		Delegate: delegate,
+	}
+	go theServer.Serve(context.Background(), listener)
+
+	alert := CreateMessageDescription("testMessage", "localhost:9090", scene.Sender.Address, to[0])
+	_, err = message.SignAndSendToMany(alert, scene.Sender, to...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-delegate.Alerts:
+			if m == nil {
+				t.Error("Alert was not split for its recipient.")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for alerts.")
+		}
+	}
+
+	// Public alerts are saved without any recipients.
+	signed, err := message.SignMessage(alert, scene.Sender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := signed.EncryptWithKey(identity.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := message.ConnectToServer(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := public.SendMessageToConnection(conn); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	select {
+	case m := <-delegate.Alerts:
+		if m == nil {
+			t.Error("Public alert was not saved for identity.Public.")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the public alert.")
+	}
+
+	// The handler has finished once the server has drained.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := theServer.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(delegate.Alerts) != 0 {
+		t.Error("Alert was saved for a recipient that isn't local.")
+	}
+}
+
+// Test 7: Limiting Requests in a Session
+type TestSessionLimitDelegate struct {
+	BasicServer
+	Running chan struct{}
+	Release chan struct{}
+}
+
+func (t TestSessionLimitDelegate) RetrieveMessageForUser(id string, author *identity.Address, forAddr *identity.Address) *message.EncryptedMessage {
+	t.Running <- struct{}{}
+	<-t.Release
+	return nil
+}
+
+func TestSessionLimit(t *testing.T) {
+	fmt.Println("--- Starting Session Limit Test")
+
+	scene, err := adTest.CreateScenario()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delegate := TestSessionLimitDelegate{
+		Running: make(chan struct{}, 5),
+		Release: make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go (&Server{
+		Key:                scene.Server,
+		Delegate:           delegate,
+		MaxSessionRequests: 2,
+	}).Serve(ctx, listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := OpenSessionOnConnection(scene.Receiver, scene.Server.Address, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	done := make(chan bool, cap(delegate.Running))
+	for i := 0; i < cap(done); i++ {
+		go func() {
+			transfer := CreateTransferMessage("missing", scene.Receiver.Address, scene.Server.Address, scene.Sender.Address)
+			session.SendMessageAndReceive(transfer)
+			done <- true
+		}()
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-delegate.Running:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for requests to be handled.")
+		}
+	}
+
+	select {
+	case <-delegate.Running:
+		t.Fatal("Session handled more requests at once than its limit.")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Every request is handled once the earlier ones finish.
+	for i := 0; i < cap(done); i++ {
+		delegate.Release <- struct{}{}
+	}
+	for i := 0; i < cap(done); i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for responses.")
+		}
+	}
+}
+
+// Test 8: Failed Requests in a Session
+type TestSessionFailureDelegate struct {
+	BasicServer
+	Key     *identity.Identity
+	Release chan struct{}
+}
+
+func (t TestSessionFailureDelegate) RetrieveMessageForUser(id string, author *identity.Address, forAddr *identity.Address) *message.EncryptedMessage {
+	<-t.Release
+	return nil
+}
+
+// Every byte read from zeros is zero
+type zeros struct{}
+
+func (zeros) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+func (t TestSessionFailureDelegate) RetrieveDataForUser(id string, author *identity.Address, forAddr *identity.Address) (*message.EncryptedMessage, io.ReadCloser) {
+	mail := message.CreateMail(author, time.Now(), id, forAddr)
+	signed, err := message.SignMessage(mail, t.Key)
+	if err != nil {
+		return nil, nil
+	}
+	enc, err := signed.EncryptWithKey(identity.Public)
+	if err != nil {
+		return nil, nil
+	}
+	return enc, ioutil.NopCloser(io.LimitReader(zeros{}, int64(wire.DefaultMaxFrameSize)+1))
+}
+
+func TestSessionFailures(t *testing.T) {
+	fmt.Println("--- Starting Session Failure Test")
+
+	scene, err := adTest.CreateScenario()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delegate := TestSessionFailureDelegate{
+		Key:     scene.Sender,
+		Release: make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go (&Server{
+		Key:      scene.Server,
+		Delegate: delegate,
+	}).Serve(ctx, listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := OpenSessionOnConnection(scene.Receiver, scene.Server.Address, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	// Responses that don't fit in a frame are replaced with an error.
+	transfer := CreateTransferMessage("large", scene.Receiver.Address, scene.Server.Address, scene.Sender.Address)
+	transfer.Data = true
+	_, typ, _, err := session.SendMessageAndReceive(transfer)
+	if err != nil || typ != wire.ErrorCode {
+		t.Error("Expected an error for a response that is too large, got", typ, err)
+	}
+
+	// Requests give up once their response takes too long.
+	session.Timeout = 100 * time.Millisecond
+	transfer = CreateTransferMessage("slow", scene.Receiver.Address, scene.Server.Address, scene.Sender.Address)
+	if _, _, _, err := session.SendMessageAndReceive(transfer); err != ErrSessionTimeout {
+		t.Error("Expected the request to time out, got", err)
+	}
+	close(delegate.Release)
+}
+
+// Test 9: Serving over TLS
+func TestTLSServer(t *testing.T) {
+	fmt.Println("--- Starting TLS Server Test")
+
+	scene, err := adTest.CreateScenario()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport, err := message.CreateTLSTransport(scene.Server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := transport.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	location := listener.Addr().String()
+
+	delegate := TestSplitDelegate{
+		Local:  map[string]bool{scene.Receiver.Address.String(): true},
+		Alerts: make(chan *message.EncryptedMessage, 1),
+	}
+
+	theServer := &Server{
+		Key:       scene.Server,
+		Delegate:  delegate,
+		Transport: transport,
+	}
+	go theServer.Serve(context.Background(), listener)
+	defer theServer.Shutdown(context.Background())
+
+	// Clients don't have certificates of their own, and pin the server.
+	defer func(old message.Transport) { message.DefaultTransport = old }(message.DefaultTransport)
+	message.DefaultTransport = &message.TLSTransport{
+		Servers: map[string]*identity.Address{location: scene.Server.Address},
+	}
+
+	receiver := *scene.Receiver.Address
+	receiver.Location = location
+
+	alert := CreateMessageDescription("testMessage", "localhost:9090", scene.Sender.Address, &receiver)
+	deliveries, err := message.SignAndSendToMany(alert, scene.Sender, &receiver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deliveries[0].Error != nil {
+		t.Fatal(deliveries[0].Error)
+	}
+
+	select {
+	case m := <-delegate.Alerts:
+		if m == nil {
+			t.Error("Alert was not saved for the receiver.")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the alert.")
+	}
+
+	// Requests are pinned to the address of the server.
+	server := *scene.Server.Address
+	server.Location = location
+
+	session, err := OpenSession(scene.Sender, &server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Close()
+}
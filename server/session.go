@@ -0,0 +1,435 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	adErrors "airdispat.ch/errors"
+	"airdispat.ch/identity"
+	"airdispat.ch/message"
+	"airdispat.ch/wire"
+	"code.google.com/p/goprotobuf/proto"
+)
+
+// A session allows a client to send many requests over one connection.
+//
+// The client opens a session by sending a SessionMessage to the server, which
+// replies with a SessionMessage of its own. After that, every frame on the
+// connection is a wire.SessionFrame. Requests carry an EncryptedMessage, and
+// the response with the same request id carries every frame that the server
+// would have written to the connection outside of a session. Requests are
+// handled concurrently, so responses may arrive in any order.
+
+var ErrSessionClosed = errors.New("Session has been closed.")
+var ErrSessionTimeout = errors.New("Session request timed out.")
+var ErrSessionResponseSize = errors.New("Session response is too large for a frame.")
+
+// How many requests in a session a Server handles at once, unless it has a
+// MaxSessionRequests of its own.
+const DefaultMaxSessionRequests = 16
+
+// How long a Session waits for the response to a request, unless it has a
+// Timeout of its own.
+const DefaultSessionTimeout = time.Minute
+
+// Room left in a frame for the SessionFrame around a response
+const sessionFrameOverhead = 32
+
+// A session being served, along with how many of its requests are being
+// handled. It is guarded by the lock of the Server.
+type serverSession struct {
+	conn     net.Conn
+	requests int
+}
+
+// Adds or removes a session, refusing to add one after Shutdown
+func (s *Server) trackSession(session *serverSession, add bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !add {
+		delete(s.sessions, session)
+		return true
+	}
+
+	if s.closed {
+		return false
+	}
+	if s.sessions == nil {
+		s.sessions = make(map[*serverSession]bool)
+	}
+	s.sessions[session] = true
+	return true
+}
+
+// Counts a request that has started or finished in a session. Once the server
+// is shutting down, the session is closed when it has no requests left.
+func (s *Server) trackSessionRequest(session *serverSession, start bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if start {
+		session.requests++
+		return
+	}
+
+	session.requests--
+	if s.closed && session.requests == 0 {
+		session.conn.Close()
+	}
+}
+
+// Takes over a connection after the client has asked for a session
+func (s *Server) handleSession(desc []byte, h message.Header, conn net.Conn) {
+	req, err := CreateSessionMessageFromBytes(desc, h)
+	if err != nil {
+		adErrors.CreateError(adErrors.UnexpectedError, "Unable to unpack session message.", s.Key.Address).Send(s.Key, conn)
+		return
+	}
+
+	if req.Version != SessionVersion {
+		adErrors.CreateError(adErrors.UnexpectedError, "Unsupported session version.", s.Key.Address).Send(s.Key, conn)
+		return
+	}
+
+	err = message.SignAndSendToConnection(CreateSessionMessage(s.Key.Address, h.From), s.Key, h.From, conn)
+	if err != nil {
+		s.handleError("Accepting Session", err)
+		return
+	}
+
+	session := &serverSession{conn: conn}
+	if !s.trackSession(session, true) {
+		return
+	}
+	defer s.trackSession(session, false)
+
+	reader := s.frameReader(conn)
+	writer := wire.NewFrameWriter(conn)
+
+	limit := s.MaxSessionRequests
+	if limit <= 0 {
+		limit = DefaultMaxSessionRequests
+	}
+	requests := make(chan struct{}, limit)
+
+	var writeLock sync.Mutex
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		// Wait for a request to finish before reading another
+		requests <- struct{}{}
+
+		frame, err := reader.ReadFrame()
+		if err == io.EOF || (err != nil && s.isClosed()) {
+			return
+		} else if err != nil {
+			s.handleError("Reading Session Frame", err)
+			return
+		}
+
+		request := &wire.SessionFrame{}
+		err = proto.Unmarshal(frame, request)
+		if err != nil {
+			s.handleError("Unmarshalling Session Frame", err)
+			return
+		}
+
+		wg.Add(1)
+		s.trackSessionRequest(session, true)
+		go func(id uint64, data []byte) {
+			defer wg.Done()
+			defer func() { <-requests }()
+			defer s.trackSessionRequest(session, false)
+
+			max := int(writer.MaxFrameSize) - sessionFrameOverhead
+			response := &sessionConn{Conn: conn, max: max}
+			newMessage, err := message.CreateEncryptedMessageFromBytes(data)
+			if err != nil {
+				s.handleError("Read Message From Session", err)
+				adErrors.CreateError(adErrors.UnexpectedError, "Unable to read message properly.", s.Key.Address).Send(s.Key, response)
+			} else {
+				s.handleMessage(newMessage, response, true)
+			}
+
+			if response.overflow {
+				s.handleError("Writing Session Response", ErrSessionResponseSize)
+				response = &sessionConn{Conn: conn, max: max}
+				adErrors.CreateError(adErrors.UnexpectedError, "Response is too large for a session.", s.Key.Address).Send(s.Key, response)
+			}
+
+			by, err := proto.Marshal(&wire.SessionFrame{
+				RequestId: &id,
+				Data:      response.Bytes(),
+			})
+
+			writeLock.Lock()
+			defer writeLock.Unlock()
+
+			if err == nil {
+				err = writer.WriteFrame(by)
+			}
+			if err != nil {
+				// The client would wait for the response forever, so end
+				// the session for every request.
+				s.handleError("Writing Session Frame", err)
+				conn.Close()
+			}
+		}(request.GetRequestId(), request.GetData())
+	}
+}
+
+// sessionConn collects the response to one request in a session so that it
+// can be sent back inside of a single SessionFrame. Writes past max bytes
+// fail.
+type sessionConn struct {
+	net.Conn
+	buf      bytes.Buffer
+	max      int
+	overflow bool
+}
+
+func (c *sessionConn) Read(b []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (c *sessionConn) Write(b []byte) (int, error) {
+	if c.overflow || c.buf.Len()+len(b) > c.max {
+		c.overflow = true
+		return 0, ErrSessionResponseSize
+	}
+	return c.buf.Write(b)
+}
+
+func (c *sessionConn) Close() error {
+	return nil
+}
+
+// Bytes returns everything written so far. It is never nil, as an empty
+// response is still a response.
+func (c *sessionConn) Bytes() []byte {
+	return append([]byte{}, c.buf.Bytes()...)
+}
+
+// Session is a client's connection to a server that carries many requests.
+// It is safe to make requests from multiple goroutines at once.
+type Session struct {
+	Sender *identity.Identity
+	Server *identity.Address
+	// How long a request waits for its response. Zero means no limit.
+	Timeout time.Duration
+
+	conn      net.Conn
+	writer    *wire.FrameWriter
+	writeLock sync.Mutex
+
+	lock    sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan []byte
+	err     error
+}
+
+// OpenSession connects to the server's location and opens a session.
+func OpenSession(sender *identity.Identity, server *identity.Address) (*Session, error) {
+	conn, err := message.ConnectToAddress(server)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := OpenSessionOnConnection(sender, server, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// OpenSessionOnConnection opens a session on a connection that has already
+// been made to the server.
+func OpenSessionOnConnection(sender *identity.Identity, server *identity.Address, conn net.Conn) (*Session, error) {
+	err := message.SignAndSendToConnection(CreateSessionMessage(sender.Address, server), sender, server, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := message.ReadMessageFromConnection(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	data, typ, h, err := reply.Reconstruct(sender, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if typ == wire.ErrorCode {
+		return nil, adErrors.CreateErrorFromBytes(data, h)
+	} else if typ != wire.SessionCode {
+		return nil, adErrors.ADUnexpectedMessageTypeError
+	}
+
+	if !server.EqualsBytes(h.From.Fingerprint) {
+		return nil, errors.New("Session was accepted by the wrong server.")
+	}
+
+	s := &Session{
+		Sender:  sender,
+		Server:  server,
+		Timeout: DefaultSessionTimeout,
+		conn:    conn,
+		writer:  wire.NewFrameWriter(conn),
+		pending: make(map[uint64]chan []byte),
+	}
+	go s.readLoop()
+
+	return s, nil
+}
+
+// Request signs and encrypts a message for the server, sends it, and returns
+// everything that the server sent in response.
+func (s *Session) Request(m message.Message) (io.Reader, error) {
+	signed, err := message.SignMessage(m, s.Sender)
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := signed.EncryptWithKey(s.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.RequestEncrypted(enc)
+}
+
+// RequestEncrypted sends an already encrypted message to the server and
+// returns everything that the server sent in response. It returns
+// ErrSessionTimeout if the response doesn't arrive within Timeout.
+func (s *Session) RequestEncrypted(enc *message.EncryptedMessage) (io.Reader, error) {
+	by, err := enc.ToBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	if s.err != nil {
+		s.lock.Unlock()
+		return nil, s.err
+	}
+	id := s.nextID
+	s.nextID++
+	response := make(chan []byte, 1)
+	s.pending[id] = response
+	s.lock.Unlock()
+
+	frame, err := proto.Marshal(&wire.SessionFrame{
+		RequestId: &id,
+		Data:      by,
+	})
+	if err == nil {
+		s.writeLock.Lock()
+		err = s.writer.WriteFrame(frame)
+		s.writeLock.Unlock()
+	}
+	if err != nil {
+		s.lock.Lock()
+		delete(s.pending, id)
+		s.lock.Unlock()
+		return nil, err
+	}
+
+	var timeout <-chan time.Time
+	if s.Timeout > 0 {
+		timer := time.NewTimer(s.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case data, ok := <-response:
+		if !ok {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			return nil, s.err
+		}
+		return bytes.NewReader(data), nil
+	case <-timeout:
+		s.lock.Lock()
+		delete(s.pending, id)
+		s.lock.Unlock()
+		return nil, ErrSessionTimeout
+	}
+}
+
+// SendMessageAndReceive sends a message within the session and reconstructs
+// the first message of the response (without timestamp support, as stored
+// mail keeps the time that it was written).
+func (s *Session) SendMessageAndReceive(m message.Message) ([]byte, string, message.Header, error) {
+	r, err := s.Request(m)
+	if err != nil {
+		return nil, "", message.Header{}, err
+	}
+
+	msg, err := message.ReadMessageFromFrameReader(wire.NewFrameReader(r))
+	if err != nil {
+		return nil, "", message.Header{}, err
+	}
+
+	return msg.Reconstruct(s.Sender, false)
+}
+
+// Close ends the session. Requests that are still waiting for a response
+// will return ErrSessionClosed.
+func (s *Session) Close() error {
+	s.fail(ErrSessionClosed)
+	return s.conn.Close()
+}
+
+// Delivers responses to the requests waiting for them
+func (s *Session) readLoop() {
+	reader := wire.NewFrameReader(s.conn)
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+
+		response := &wire.SessionFrame{}
+		err = proto.Unmarshal(frame, response)
+		if err != nil {
+			s.fail(err)
+			s.conn.Close()
+			return
+		}
+
+		s.lock.Lock()
+		waiting, ok := s.pending[response.GetRequestId()]
+		delete(s.pending, response.GetRequestId())
+		s.lock.Unlock()
+
+		if ok {
+			waiting <- response.GetData()
+		}
+	}
+}
+
+// Stops the session with err, waking every pending request
+func (s *Session) fail(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.err != nil {
+		return
+	}
+
+	s.err = err
+	for _, v := range s.pending {
+		close(v)
+	}
+	s.pending = nil
+}
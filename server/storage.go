@@ -0,0 +1,410 @@
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"airdispat.ch/crypto"
+	"airdispat.ch/identity"
+	"airdispat.ch/message"
+	"airdispat.ch/wire"
+)
+
+// StoredMail is an outgoing message kept by a FileStore until the
+// recipient transfers it.
+type StoredMail struct {
+	Mail     *message.EncryptedMessage
+	Name     string
+	SentTime time.Time
+	Public   bool
+}
+
+// Mailbox holds all of the messages that a FileStore keeps for one user.
+type Mailbox struct {
+	Incoming []*message.EncryptedMessage
+	Outgoing map[string]StoredMail
+	Public   []StoredMail
+}
+
+func newMailbox() *Mailbox {
+	return &Mailbox{
+		Incoming: make([]*message.EncryptedMessage, 0),
+		Outgoing: make(map[string]StoredMail),
+	}
+}
+
+// The record types that may appear in the log.
+const (
+	recordIncoming byte = iota
+	recordOutgoing
+	recordLocalUser
+)
+
+// storeRecord is the gob-encoded entry that is appended to the log for every
+// message stored.
+type storeRecord struct {
+	Type    byte
+	User    string
+	Name    string
+	Time    time.Time
+	Public  bool
+	Message []byte
+}
+
+// FileStore is a ServerDelegate that keeps incoming alerts, outgoing mail
+// and public notices in an append-only log inside of a directory. The log is
+// replayed into memory when the store is opened, so messages survive
+// restarts. It is safe for concurrent use by many handleClient goroutines.
+type FileStore struct {
+	BasicServer
+
+	lock      sync.RWMutex
+	log       *os.File
+	size      int64
+	mailboxes map[string]*Mailbox
+	local     map[string]bool
+}
+
+// OpenFileStore opens (or creates) a FileStore in the directory dir.
+func OpenFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, "mail.log"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FileStore{
+		log:       file,
+		mailboxes: make(map[string]*Mailbox),
+		local:     make(map[string]bool),
+	}
+
+	err = f.replay()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Close closes the underlying log.
+func (f *FileStore) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.log.Close()
+}
+
+// replay loads every record in the log into memory. A partially written
+// record at the end of the log (from a crash) is truncated away, including
+// one whose length or contents were torn.
+func (f *FileStore) replay() error {
+	info, err := f.log.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.log.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	counter := &countingReader{Reader: f.log}
+	// Records may be larger than any frame a client could send, so the log
+	// has no limit of its own. Torn lengths are caught by reaching the end.
+	reader := wire.NewFrameReader(counter)
+	reader.MaxFrameSize = math.MaxInt32
+	var valid int64
+
+	for {
+		data, err := reader.ReadFrame()
+		if err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		record := &storeRecord{}
+		err = gob.NewDecoder(bytes.NewReader(data)).Decode(record)
+		if err == nil {
+			err = f.apply(record)
+		}
+		if err != nil && counter.n == info.Size() {
+			// The last record was torn.
+			break
+		} else if err != nil {
+			return err
+		}
+		valid = counter.n
+	}
+
+	if valid != info.Size() {
+		if err := f.log.Truncate(valid); err != nil {
+			return err
+		}
+	}
+	f.size = valid
+
+	_, err = f.log.Seek(valid, io.SeekStart)
+	return err
+}
+
+// Returns a user in the form that Address.String returns. Logs written before
+// addresses were base58 encoded key users by hex. The empty user holds public
+// alerts.
+func normalizeUser(user string) (string, error) {
+	if user == "" {
+		return "", nil
+	}
+
+	fingerprint, err := crypto.StringToAddress(user)
+	if err != nil {
+		return "", err
+	}
+	return crypto.AddressToString(fingerprint), nil
+}
+
+// apply adds a record to the in-memory mailboxes. The lock must be held.
+// Users that can't be parsed are kept as they are, so that a bad record
+// doesn't stop the log from being opened.
+func (f *FileStore) apply(r *storeRecord) error {
+	user, err := normalizeUser(r.User)
+	if err != nil {
+		user = r.User
+	}
+
+	if r.Type == recordLocalUser {
+		f.local[user] = true
+		return nil
+	}
+
+	m, err := message.CreateEncryptedMessageFromBytes(r.Message)
+	if err != nil {
+		return err
+	}
+
+	box, ok := f.mailboxes[user]
+	if !ok {
+		box = newMailbox()
+		f.mailboxes[user] = box
+	}
+
+	switch r.Type {
+	case recordIncoming:
+		box.Incoming = append(box.Incoming, m)
+	case recordOutgoing:
+		mail := StoredMail{
+			Mail:     m,
+			Name:     r.Name,
+			SentTime: r.Time,
+			Public:   r.Public,
+		}
+		box.Outgoing[mail.Name] = mail
+		if mail.Public {
+			box.Public = append(box.Public, mail)
+		}
+	default:
+		return errors.New("Unknown record type in mail log.")
+	}
+	return nil
+}
+
+// append durably writes a record to the log and then applies it.
+func (f *FileStore) append(r *storeRecord) error {
+	user, err := normalizeUser(r.User)
+	if err != nil {
+		return err
+	}
+	r.User = user
+
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(r); err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	by := wire.PrefixBytes(buf.Bytes())
+	_, err = f.log.Write(by)
+	if err == nil {
+		err = f.log.Sync()
+	}
+	if err != nil {
+		// Don't leave part of the record for the next one to follow.
+		if err := f.log.Truncate(f.size); err == nil {
+			f.log.Seek(f.size, io.SeekStart)
+		}
+		return err
+	}
+	f.size += int64(len(by))
+
+	return f.apply(r)
+}
+
+// AddLocalUser makes the store keep alerts for user. Local users are kept in
+// the log, so they only have to be added once.
+func (f *FileStore) AddLocalUser(user *identity.Address) error {
+	f.lock.RLock()
+	added := f.local[user.String()]
+	f.lock.RUnlock()
+
+	if added {
+		return nil
+	}
+	return f.append(&storeRecord{
+		Type: recordLocalUser,
+		User: user.String(),
+		Time: time.Now(),
+	})
+}
+
+// IsLocalUser reports whether alerts for addr are kept by the store. Until a
+// local user has been added, every user is local.
+func (f *FileStore) IsLocalUser(addr *identity.Address) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	return len(f.local) == 0 || f.local[addr.String()]
+}
+
+// SaveMessageDescription stores an incoming alert in the mailbox of the
+// recipient that it is for. Public alerts are kept for identity.Public.
+func (f *FileStore) SaveMessageDescription(desc *message.EncryptedMessage, forAddr *identity.Address) {
+	by, err := desc.ToBytes()
+	if err != nil {
+		f.HandleError(&ServerError{"Saving Message Description", err})
+		return
+	}
+
+	err = f.append(&storeRecord{
+		Type:    recordIncoming,
+		User:    forAddr.String(),
+		Time:    time.Now(),
+		Message: by,
+	})
+	if err != nil {
+		f.HandleError(&ServerError{"Saving Message Description", err})
+	}
+}
+
+// StoreOutgoingMessage keeps a message from user until its recipients
+// transfer it. Public messages are also returned in message lists.
+func (f *FileStore) StoreOutgoingMessage(user *identity.Address, m *message.EncryptedMessage, public bool) (StoredMail, error) {
+	by, err := m.ToBytes()
+	if err != nil {
+		return StoredMail{}, err
+	}
+
+	name := make([]byte, 16)
+	if _, err := io.ReadFull(crypto.Random, name); err != nil {
+		return StoredMail{}, err
+	}
+
+	r := &storeRecord{
+		Type:    recordOutgoing,
+		User:    user.String(),
+		Name:    hex.EncodeToString(name),
+		Time:    time.Now(),
+		Public:  public,
+		Message: by,
+	}
+	if err := f.append(r); err != nil {
+		return StoredMail{}, err
+	}
+
+	return StoredMail{
+		Mail:     m,
+		Name:     r.Name,
+		SentTime: r.Time,
+		Public:   public,
+	}, nil
+}
+
+// IncomingMessagesForUser returns all of the alerts received for user.
+func (f *FileStore) IncomingMessagesForUser(user *identity.Address) []*message.EncryptedMessage {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	box, ok := f.mailboxes[user.String()]
+	if !ok {
+		return nil
+	}
+
+	output := make([]*message.EncryptedMessage, len(box.Incoming))
+	copy(output, box.Incoming)
+	return output
+}
+
+// RetrieveMessageForUser returns the outgoing message named name from author.
+func (f *FileStore) RetrieveMessageForUser(name string, author *identity.Address, forAddr *identity.Address) *message.EncryptedMessage {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	box, ok := f.mailboxes[author.String()]
+	if !ok {
+		return nil
+	}
+
+	mail, ok := box.Outgoing[name]
+	if !ok {
+		return nil
+	}
+
+	return mail.Mail
+}
+
+// RetrieveMessageListForUser returns the public messages from author that
+// were sent after since.
+func (f *FileStore) RetrieveMessageListForUser(since uint64, author *identity.Address, forAddr *identity.Address) []*message.EncryptedMessage {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	// Get the `TimeSince` field
+	timeSince := time.Unix(int64(since), 0)
+	output := make([]*message.EncryptedMessage, 0)
+
+	box, ok := f.mailboxes[author.String()]
+	if !ok {
+		return nil
+	}
+
+	for _, v := range box.Public {
+		if v.SentTime.After(timeSince) {
+			output = append(output, v.Mail)
+		}
+	}
+	return output
+}
+
+// RetrieveDataForUser is not supported by the FileStore, which does not
+// store data messages.
+func (f *FileStore) RetrieveDataForUser(name string, author *identity.Address, forAddr *identity.Address) (*message.EncryptedMessage, io.ReadCloser) {
+	return nil, nil
+}
+
+// countingReader keeps track of how many bytes have been read through it.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"airdispat.ch/identity"
+	"airdispat.ch/message"
+	"airdispat.ch/wire"
+)
+
+func createTestAlert(t *testing.T, from *identity.Identity, to *identity.Address) *message.EncryptedMessage {
+	desc := CreateMessageDescription("testMessage", "localhost:9090", from.Address, to)
+	signed, err := message.SignMessage(desc, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := signed.EncryptWithKey(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enc
+}
+
+func TestFileStorePersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	sender, err := identity.CreateIdentityOfKind(identity.KindCurve25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiver, err := identity.CreateIdentityOfKind(identity.KindCurve25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Save alerts concurrently, as handleClient would.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		alert := createTestAlert(t, sender, receiver.Address)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.SaveMessageDescription(alert, receiver.Address)
+		}()
+	}
+	wg.Wait()
+
+	public, err := store.StoreOutgoingMessage(sender.Address, createTestAlert(t, sender, receiver.Address), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash in the middle of writing a record.
+	log, err := os.OpenFile(filepath.Join(dir, "mail.log"), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Write([]byte("AD\x00\x00\x10"))
+	log.Close()
+
+	store, err = OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if n := len(store.IncomingMessagesForUser(receiver.Address)); n != 10 {
+		t.Error("Expected 10 incoming alerts, got", n)
+	}
+
+	if store.RetrieveMessageForUser(public.Name, sender.Address, receiver.Address) == nil {
+		t.Error("Unable to retrieve stored outgoing message.")
+	}
+
+	if n := len(store.RetrieveMessageListForUser(0, sender.Address, receiver.Address)); n != 1 {
+		t.Error("Expected 1 public message, got", n)
+	}
+}
+
+func TestFileStoreTornRecords(t *testing.T) {
+	sender, err := identity.CreateIdentityOfKind(identity.KindCurve25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiver, err := identity.CreateIdentityOfKind(identity.KindCurve25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tails := map[string][]byte{
+		"oversized length": []byte("AD\x7f\xff\xff\xff"),
+		"undecodable":      wire.PrefixBytes([]byte("not a record")),
+	}
+
+	for name, tail := range tails {
+		dir := t.TempDir()
+
+		store, err := OpenFileStore(dir)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		store.SaveMessageDescription(createTestAlert(t, sender, receiver.Address), receiver.Address)
+		store.Close()
+
+		log, err := os.OpenFile(filepath.Join(dir, "mail.log"), os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		log.Write(tail)
+		log.Close()
+
+		store, err = OpenFileStore(dir)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if n := len(store.IncomingMessagesForUser(receiver.Address)); n != 1 {
+			t.Error(name, "expected 1 incoming alert, got", n)
+		}
+
+		// Records written after the torn one must survive another replay.
+		store.SaveMessageDescription(createTestAlert(t, sender, receiver.Address), receiver.Address)
+		store.Close()
+
+		store, err = OpenFileStore(dir)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if n := len(store.IncomingMessagesForUser(receiver.Address)); n != 2 {
+			t.Error(name, "expected 2 incoming alerts, got", n)
+		}
+		store.Close()
+	}
+}
+
+func TestFileStoreHexUsers(t *testing.T) {
+	dir := t.TempDir()
+
+	sender, err := identity.CreateIdentityOfKind(identity.KindCurve25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiver, err := identity.CreateIdentityOfKind(identity.KindCurve25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Write a record the way that earlier versions did, keyed by hex.
+	by, err := createTestAlert(t, sender, receiver.Address).ToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.append(&storeRecord{
+		Type:    recordIncoming,
+		User:    hex.EncodeToString(receiver.Address.Fingerprint),
+		Time:    time.Now(),
+		Message: by,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SaveMessageDescription(createTestAlert(t, sender, receiver.Address), receiver.Address)
+
+	// Users with invalid addresses are never written to the log.
+	if err := store.append(&storeRecord{Type: recordIncoming, User: "abc", Message: by}); err == nil {
+		t.Error("Expected a record with an invalid user to be refused.")
+	}
+	bad := identity.CreateAddressFromBytes([]byte{1, 2, 3})
+	(&Server{Delegate: store}).handleMessageDescription(&message.EncryptedMessage{
+		Data: by,
+		Header: map[string]message.EncryptionHeader{
+			bad.String(): {To: bad},
+		},
+	})
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// But records that have one anyway don't stop the log from opening.
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(&storeRecord{Type: recordIncoming, User: "abc", Message: by}); err != nil {
+		t.Fatal(err)
+	}
+	log, err := os.OpenFile(filepath.Join(dir, "mail.log"), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Write(wire.PrefixBytes(buf.Bytes()))
+	log.Close()
+
+	store, err = OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if n := len(store.IncomingMessagesForUser(receiver.Address)); n != 2 {
+		t.Error("Expected 2 incoming alerts, got", n)
+	}
+}
+
+func TestFileStoreLocalUsers(t *testing.T) {
+	dir := t.TempDir()
+
+	local, err := identity.CreateIdentityOfKind(identity.KindCurve25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote, err := identity.CreateIdentityOfKind(identity.KindCurve25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !store.IsLocalUser(remote.Address) {
+		t.Error("Expected every user to be local before any are added.")
+	}
+
+	if err := store.AddLocalUser(local.Address); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if !store.IsLocalUser(local.Address) {
+		t.Error("Expected the added user to be local after reopening.")
+	}
+	if store.IsLocalUser(remote.Address) {
+		t.Error("Expected other users not to be local.")
+	}
+}
@@ -1,8 +1,6 @@
 package testing
 
 import (
-	"errors"
-
 	"airdispat.ch/identity"
 	"airdispat.ch/routing"
 )
@@ -18,7 +16,7 @@ func (t *StaticRouter) Lookup(addr string, typ routing.LookupType) (*identity.Ad
 			return x.Address, nil
 		}
 	}
-	return nil, errors.New("Unable to find address.")
+	return nil, routing.ErrAddressNotFound
 }
 
 func (t *StaticRouter) Register(*identity.Identity, string, map[string]routing.Redirect) error {
@@ -26,5 +24,10 @@ func (t *StaticRouter) Register(*identity.Identity, string, map[string]routing.R
 }
 
 func (t *StaticRouter) LookupAlias(alias string, typ routing.LookupType) (*identity.Address, error) {
-	return nil, nil
+	for _, x := range t.Keys {
+		if x.Address.Alias != "" && x.Address.Alias == alias {
+			return x.Address, nil
+		}
+	}
+	return nil, routing.ErrAddressNotFound
 }
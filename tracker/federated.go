@@ -0,0 +1,259 @@
+package tracker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"airdispat.ch/crypto"
+	adErrors "airdispat.ch/errors"
+	"airdispat.ch/identity"
+	"airdispat.ch/message"
+	"airdispat.ch/routing"
+)
+
+// FederatedRouter looks up addresses with several routers (usually one
+// Router for each of a list of trackers) at once, and only trusts an address
+// once Quorum of them agree on its keys and location.
+//
+// Lookups return adErrors.ADTrackerVerificationError if the routers disagree,
+// and adErrors.ADTrackerListQueryError if none of them know the address.
+type FederatedRouter struct {
+	Routers []routing.Router
+	// How many routers must agree. If zero, a majority is required.
+	Quorum int
+}
+
+var ErrInvalidQuorum = errors.New("Quorum must be between zero and the number of routers.")
+
+func CreateFederatedRouter(quorum int, routers ...routing.Router) (*FederatedRouter, error) {
+	if quorum < 0 || quorum > len(routers) {
+		return nil, ErrInvalidQuorum
+	}
+
+	return &FederatedRouter{
+		Routers: routers,
+		Quorum:  quorum,
+	}, nil
+}
+
+// CreateTrackerListRouter creates a FederatedRouter that queries each of a
+// list of trackers.
+func CreateTrackerListRouter(origin *identity.Identity, quorum int, trackers ...*identity.Address) (*FederatedRouter, error) {
+	routers := make([]routing.Router, len(trackers))
+	for i, v := range trackers {
+		routers[i] = CreateRouter(origin, v)
+	}
+	return CreateFederatedRouter(quorum, routers...)
+}
+
+// Register registers the identity with every router, returning the first
+// error once all of them have been tried.
+func (f *FederatedRouter) Register(id *identity.Identity, alias string, redirects map[string]routing.Redirect) error {
+	return f.each(func(r routing.Router) error {
+		return r.Register(id, alias, redirects)
+	})
+}
+
+// RegisterSuccession stores a signed succession with every router that
+// stores successions, returning the first error once all of them have been
+// tried.
+func (f *FederatedRouter) RegisterSuccession(signed []byte) error {
+	return f.each(func(r routing.Router) error {
+		if s, ok := r.(routing.SuccessionRouter); ok {
+			return s.RegisterSuccession(signed)
+		}
+		return nil
+	})
+}
+
+// LookupSuccession asks every router that stores successions for the
+// succession of an address. Successions are signed by the address they hand
+// over, so a single router is enough, but the routers must not know of
+// different successors.
+func (f *FederatedRouter) LookupSuccession(addr string) ([]byte, error) {
+	results := make([][]byte, len(f.Routers))
+	errs := make([]error, len(f.Routers))
+
+	var wg sync.WaitGroup
+	for i, v := range f.Routers {
+		s, ok := v.(routing.SuccessionRouter)
+		if !ok {
+			errs[i] = routing.ErrAddressNotFound
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, s routing.SuccessionRouter) {
+			defer wg.Done()
+			results[i], errs[i] = s.LookupSuccession(addr)
+		}(i, s)
+	}
+	wg.Wait()
+
+	var found []byte
+	var successor *identity.Address
+	notFound := 0
+	var firstErr error
+	for i, err := range errs {
+		if err == nil {
+			succ, err := message.VerifySuccession(results[i])
+			if err != nil {
+				return nil, adErrors.ADTrackerVerificationError
+			} else if successor != nil && !successor.EqualsBytes(succ.Successor.Fingerprint) {
+				return nil, adErrors.ADTrackerVerificationError
+			}
+			found, successor = results[i], succ.Successor
+		} else if routing.IsNotFound(err) {
+			notFound++
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if found != nil {
+		return found, nil
+	} else if notFound == len(f.Routers) {
+		return nil, adErrors.ADTrackerListQueryError
+	}
+	return nil, firstErr
+}
+
+// Runs do with every router at once, returning the first error once all of
+// them have finished
+func (f *FederatedRouter) each(do func(r routing.Router) error) error {
+	errs := make([]error, len(f.Routers))
+
+	var wg sync.WaitGroup
+	for i, v := range f.Routers {
+		wg.Add(1)
+		go func(i int, r routing.Router) {
+			defer wg.Done()
+			errs[i] = do(r)
+		}(i, v)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FederatedRouter) Lookup(addr string, name routing.LookupType) (*identity.Address, error) {
+	found, _, err := f.LookupWithExpiry(addr, name)
+	return found, err
+}
+
+func (f *FederatedRouter) LookupAlias(alias string, name routing.LookupType) (*identity.Address, error) {
+	found, _, err := f.LookupAliasWithExpiry(alias, name)
+	return found, err
+}
+
+func (f *FederatedRouter) LookupWithExpiry(addr string, name routing.LookupType) (*identity.Address, time.Time, error) {
+	return f.lookup(func(r routing.Router) (*identity.Address, time.Time, error) {
+		if e, ok := r.(routing.ExpiringRouter); ok {
+			return e.LookupWithExpiry(addr, name)
+		}
+		found, err := r.Lookup(addr, name)
+		return found, time.Time{}, err
+	})
+}
+
+func (f *FederatedRouter) LookupAliasWithExpiry(alias string, name routing.LookupType) (*identity.Address, time.Time, error) {
+	return f.lookup(func(r routing.Router) (*identity.Address, time.Time, error) {
+		if e, ok := r.(routing.ExpiringRouter); ok {
+			return e.LookupAliasWithExpiry(alias, name)
+		}
+		found, err := r.LookupAlias(alias, name)
+		return found, time.Time{}, err
+	})
+}
+
+// The parts of an address that routers must agree on
+type agreement struct {
+	fingerprint   string
+	encryptionKey string
+	signingKey    string
+	location      string
+}
+
+type lookupResult struct {
+	addr    *identity.Address
+	expires time.Time
+	err     error
+}
+
+func (f *FederatedRouter) lookup(lookup func(r routing.Router) (*identity.Address, time.Time, error)) (*identity.Address, time.Time, error) {
+	results := make([]lookupResult, len(f.Routers))
+
+	var wg sync.WaitGroup
+	for i, v := range f.Routers {
+		wg.Add(1)
+		go func(i int, r routing.Router) {
+			defer wg.Done()
+			addr, expires, err := lookup(r)
+			results[i] = lookupResult{addr, expires, err}
+		}(i, v)
+	}
+	wg.Wait()
+
+	quorum := f.Quorum
+	if quorum < 0 || quorum > len(f.Routers) {
+		return nil, time.Time{}, ErrInvalidQuorum
+	} else if quorum == 0 {
+		quorum = len(f.Routers)/2 + 1
+	}
+
+	groups := make(map[agreement][]lookupResult)
+	notFound := 0
+	var firstErr error
+	for _, v := range results {
+		if v.err == nil && v.addr != nil {
+			key := agreement{
+				fingerprint:   string(v.addr.Fingerprint),
+				encryptionKey: string(crypto.EncryptionKeyToBytes(v.addr.EncryptionKey)),
+				signingKey:    string(crypto.SigningKeyToBytes(v.addr.SigningKey)),
+				location:      v.addr.Location,
+			}
+			groups[key] = append(groups[key], v)
+		} else if v.err == nil || routing.IsNotFound(v.err) {
+			notFound++
+		} else if firstErr == nil {
+			firstErr = v.err
+		}
+	}
+
+	// With a small quorum, routers that disagree may each reach it
+	var agreed []lookupResult
+	for _, group := range groups {
+		if len(group) < quorum {
+			continue
+		} else if agreed != nil {
+			return nil, time.Time{}, adErrors.ADTrackerVerificationError
+		}
+		agreed = group
+	}
+
+	if agreed != nil {
+		// Trust the address until the first of the agreeing routers expires it
+		expires := agreed[0].expires
+		for _, v := range agreed[1:] {
+			if !v.expires.IsZero() && (expires.IsZero() || v.expires.Before(expires)) {
+				expires = v.expires
+			}
+		}
+		return agreed[0].addr, expires, nil
+	}
+
+	if len(groups) > 1 || (len(groups) == 1 && notFound > 0) {
+		return nil, time.Time{}, adErrors.ADTrackerVerificationError
+	} else if notFound == len(f.Routers) {
+		return nil, time.Time{}, adErrors.ADTrackerListQueryError
+	} else if firstErr != nil {
+		return nil, time.Time{}, firstErr
+	}
+	return nil, time.Time{}, adErrors.ADTrackerVerificationError
+}
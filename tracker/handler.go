@@ -0,0 +1,214 @@
+package tracker
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	adErrors "airdispat.ch/errors"
+	"airdispat.ch/identity"
+	"airdispat.ch/message"
+	"airdispat.ch/routing"
+	"airdispat.ch/wire"
+)
+
+// Handler lets a server.Server act as a tracker. Add it to the Handlers of
+// the server to store registrations and answer queries for them.
+type Handler struct {
+	Key *identity.Identity
+	// If set, the tracker is authoritative for this domain, and only accepts
+	// aliases of the form user@Domain.
+	Domain string
+
+	lock        sync.RWMutex
+	addresses   map[string]*record
+	aliases     map[string]string
+	successions map[string]*successionRecord
+}
+
+// A registration along with the signed bytes it was verified from
+type record struct {
+	reg    *Registration
+	signed []byte
+}
+
+// A succession along with the signed bytes it was verified from
+type successionRecord struct {
+	succ   *message.Succession
+	signed []byte
+}
+
+func CreateHandler(key *identity.Identity) *Handler {
+	return &Handler{
+		Key:         key,
+		addresses:   make(map[string]*record),
+		aliases:     make(map[string]string),
+		successions: make(map[string]*successionRecord),
+	}
+}
+
+func (t *Handler) HandlesType(typ string) bool {
+	return typ == wire.RegistrationCode || typ == wire.QueryCode || typ == wire.SuccessionRequestCode
+}
+
+func (t *Handler) HandleMessage(typ string, data []byte, h message.Header, conn net.Conn) ([]message.Message, error) {
+	switch typ {
+	case wire.RegistrationCode:
+		return t.handleRegistration(data, h)
+	case wire.QueryCode:
+		return t.handleQuery(data, h)
+	case wire.SuccessionRequestCode:
+		return t.handleSuccession(data, h)
+	}
+	return nil, errors.New("Tracker can't handle message type " + typ + ".")
+}
+
+// Stores a signed registration. Anyone may send a registration to the
+// tracker, as it must be signed by the address it registers.
+func (t *Handler) handleRegistration(data []byte, h message.Header) ([]message.Message, error) {
+	req, err := CreateRegistrationRequestFromBytes(data, h)
+	if err != nil {
+		return nil, err
+	}
+
+	reg, err := VerifyRegistration(req.Registration)
+	if err == ErrRegistrationExpired {
+		return t.errorMessage(adErrors.NotAuthorized, "Registration has expired."), nil
+	} else if err != nil {
+		return t.errorMessage(adErrors.InvalidSignature, "Registration is not signed by the address it registers."), nil
+	}
+
+	if reg.Address.Location == "" {
+		return t.errorMessage(adErrors.UnexpectedError, "Registration must have a location."), nil
+	}
+
+	alias := normalizeAlias(reg.Address.Alias)
+	if t.Domain != "" && alias != "" {
+		_, domain, err := routing.SplitAlias(alias)
+		if err != nil || domain != strings.ToLower(t.Domain) {
+			return t.errorMessage(adErrors.NotAuthorized, "Tracker only registers aliases for "+t.Domain+"."), nil
+		}
+	}
+
+	addr := reg.Address.String()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// Don't allow an old registration to replace a newer one
+	old, ok := t.addresses[addr]
+	if ok && old.reg.Timestamp.After(reg.Timestamp) {
+		return t.errorMessage(adErrors.NotAuthorized, "A newer registration is already stored."), nil
+	}
+
+	if owner, ok := t.aliases[alias]; alias != "" && ok && owner != addr && t.current(owner) != nil {
+		return t.errorMessage(adErrors.NotAuthorized, "Alias is already registered to another address."), nil
+	}
+
+	// Free the alias that the address used to have
+	if ok {
+		oldAlias := normalizeAlias(old.reg.Address.Alias)
+		if oldAlias != alias && t.aliases[oldAlias] == addr {
+			delete(t.aliases, oldAlias)
+		}
+	}
+
+	t.addresses[addr] = &record{
+		reg:    reg,
+		signed: req.Registration,
+	}
+	if alias != "" {
+		t.aliases[alias] = addr
+	}
+
+	return []message.Message{CreateQueryResponse(req.Registration, t.Key.Address, h.From)}, nil
+}
+
+// Stores a signed succession. Once an address has been handed over, it can't
+// be handed over to a different key. The first succession wins for good, so
+// a stolen key can be used to lock its owner out of their address (see
+// message.SignSuccession).
+func (t *Handler) handleSuccession(data []byte, h message.Header) ([]message.Message, error) {
+	req, err := CreateSuccessionRequestFromBytes(data, h)
+	if err != nil {
+		return nil, err
+	}
+
+	succ, err := message.VerifySuccession(req.Succession)
+	if err != nil {
+		return t.errorMessage(adErrors.InvalidSignature, "Succession is not signed by the address it hands over."), nil
+	}
+
+	addr := succ.Address.String()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if old, ok := t.successions[addr]; ok && !old.succ.Successor.EqualsBytes(succ.Successor.Fingerprint) {
+		return t.errorMessage(adErrors.NotAuthorized, "Address has already been handed over to another key."), nil
+	}
+	t.successions[addr] = &successionRecord{
+		succ:   succ,
+		signed: req.Succession,
+	}
+
+	return []message.Message{CreateSuccessionResponse(req.Succession, t.Key.Address, h.From)}, nil
+}
+
+// Returns the form of an alias that registrations are stored by. Aliases
+// match regardless of case and surrounding space, both here and in the
+// AliasRouter.
+func normalizeAlias(alias string) string {
+	return strings.ToLower(strings.TrimSpace(alias))
+}
+
+// Responds with the registration for an address or alias, or the succession
+// of an address
+func (t *Handler) handleQuery(data []byte, h message.Header) ([]message.Message, error) {
+	q, err := CreateQueryFromBytes(data, h)
+	if err != nil {
+		return nil, err
+	}
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if q.Succession {
+		var found *successionRecord
+		if q.Address != nil {
+			found = t.successions[q.Address.String()]
+		}
+		if found == nil {
+			return t.errorMessage(adErrors.AddressNotFound, "Tracker has no succession for that address."), nil
+		}
+		return []message.Message{CreateSuccessionResponse(found.signed, t.Key.Address, h.From)}, nil
+	}
+
+	var found *record
+	if q.Address != nil {
+		found = t.current(q.Address.String())
+	} else if q.Alias != "" {
+		found = t.current(t.aliases[normalizeAlias(q.Alias)])
+	}
+
+	if found == nil {
+		return t.errorMessage(adErrors.AddressNotFound, "Tracker has no registration for that address."), nil
+	}
+
+	return []message.Message{CreateQueryResponse(found.signed, t.Key.Address, h.From)}, nil
+}
+
+// Returns the stored registration of an address if it hasn't expired
+func (t *Handler) current(addr string) *record {
+	found, ok := t.addresses[addr]
+	if !ok || time.Now().After(found.reg.Expires) {
+		return nil
+	}
+	return found
+}
+
+func (t *Handler) errorMessage(code adErrors.Code, description string) []message.Message {
+	return []message.Message{adErrors.CreateError(code, description, t.Key.Address)}
+}
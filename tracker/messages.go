@@ -0,0 +1,397 @@
+// Package tracker provides a directory of addresses for the AirDispatch
+// network. A tracker is a server.Server with a tracker Handler, which stores
+// the registrations that addresses send it. The tracker Router registers
+// with and queries a tracker.
+package tracker
+
+import (
+	"time"
+
+	"airdispat.ch/crypto"
+	"airdispat.ch/identity"
+	"airdispat.ch/message"
+	"airdispat.ch/routing"
+	"airdispat.ch/wire"
+	"code.google.com/p/goprotobuf/proto"
+)
+
+// Tracker messages carry the encryption key of the sender, so that the
+// tracker can respond without looking it up.
+func createHeader(from *identity.Address, to ...*identity.Address) message.Header {
+	header := message.CreateHeader(from, to...)
+	header.EncryptionKey = crypto.EncryptionKeyToBytes(from.EncryptionKey)
+	return header
+}
+
+// Registration is a record of the keys and location of an address, along
+// with an optional alias and redirects (keyed by LookupType). Registrations
+// are signed by the address that they register (see SignRegistration).
+type Registration struct {
+	Address   *identity.Address
+	Redirects map[string]routing.Redirect
+	Timestamp time.Time
+	Expires   time.Time
+	h         message.Header
+}
+
+func CreateRegistration(id *identity.Identity, alias string, redirects map[string]routing.Redirect, lifetime time.Duration) *Registration {
+	addr := *id.Address
+	addr.Alias = alias
+
+	if redirects == nil {
+		redirects = make(map[string]routing.Redirect)
+	}
+
+	now := time.Now()
+	return &Registration{
+		Address:   &addr,
+		Redirects: redirects,
+		Timestamp: now,
+		Expires:   now.Add(lifetime),
+		h:         message.CreateHeader(id.Address),
+	}
+}
+
+func CreateRegistrationFromBytes(by []byte, h message.Header) (*Registration, error) {
+	unmarsh := &wire.TrackerRegistration{}
+	err := proto.Unmarshal(by, unmarsh)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptionKey, err := crypto.BytesToEncryptionKey(unmarsh.GetEncryptionKey())
+	if err != nil {
+		return nil, err
+	}
+
+	signingKey, err := crypto.BytesToSigningKey(unmarsh.GetSigningKey())
+	if err != nil {
+		return nil, err
+	}
+
+	redirects := make(map[string]routing.Redirect)
+	for _, v := range unmarsh.GetRedirect() {
+		redirect := routing.Redirect{
+			Type:  routing.LookupType(v.GetType()),
+			Alias: v.GetAlias(),
+		}
+		if len(v.GetAddress()) != 0 {
+			redirect.Fingerprint = identity.CreateAddressFromBytes(v.GetAddress()).String()
+		}
+		redirects[v.GetType()] = redirect
+	}
+
+	return &Registration{
+		Address: &identity.Address{
+			Fingerprint:   unmarsh.GetAddress(),
+			EncryptionKey: encryptionKey,
+			SigningKey:    signingKey,
+			Location:      unmarsh.GetLocation(),
+			Alias:         unmarsh.GetAlias(),
+		},
+		Redirects: redirects,
+		Timestamp: time.Unix(int64(unmarsh.GetTimestamp()), 0),
+		Expires:   time.Unix(int64(unmarsh.GetExpires()), 0),
+		h:         h,
+	}, nil
+}
+
+func (m *Registration) ToBytes() []byte {
+	redirects := make([]*wire.TrackerRedirect, 0, len(m.Redirects))
+	for _, v := range m.Redirects {
+		redirect := &wire.TrackerRedirect{
+			Type: proto.String(string(v.Type)),
+		}
+		if v.Fingerprint != "" {
+			if addr, err := identity.CreateAddressFromString(v.Fingerprint); err == nil {
+				redirect.Address = addr.Fingerprint
+			}
+		}
+		if v.Alias != "" {
+			redirect.Alias = proto.String(v.Alias)
+		}
+		redirects = append(redirects, redirect)
+	}
+
+	toData := &wire.TrackerRegistration{
+		Address:       m.Address.Fingerprint,
+		EncryptionKey: crypto.EncryptionKeyToBytes(m.Address.EncryptionKey),
+		SigningKey:    crypto.SigningKeyToBytes(m.Address.SigningKey),
+		Location:      &m.Address.Location,
+		Alias:         &m.Address.Alias,
+		Redirect:      redirects,
+		Timestamp:     proto.Uint64(uint64(m.Timestamp.Unix())),
+		Expires:       proto.Uint64(uint64(m.Expires.Unix())),
+	}
+	by, err := proto.Marshal(toData)
+	if err != nil {
+		panic("Can't marshal Registration.")
+	}
+	return by
+}
+
+func (m *Registration) Type() string {
+	return wire.RegistrationRecordCode
+}
+
+func (m *Registration) Header() message.Header {
+	return m.h
+}
+
+// RegistrationRequest asks a tracker to store a signed Registration.
+type RegistrationRequest struct {
+	Registration []byte
+	h            message.Header
+}
+
+func CreateRegistrationRequest(signedRegistration []byte, from *identity.Address, tracker *identity.Address) *RegistrationRequest {
+	return &RegistrationRequest{
+		Registration: signedRegistration,
+		h:            createHeader(from, tracker),
+	}
+}
+
+func CreateRegistrationRequestFromBytes(by []byte, h message.Header) (*RegistrationRequest, error) {
+	unmarsh := &wire.TrackerRegistrationRequest{}
+	err := proto.Unmarshal(by, unmarsh)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RegistrationRequest{
+		Registration: unmarsh.GetRegistration(),
+		h:            h,
+	}, nil
+}
+
+func (m *RegistrationRequest) ToBytes() []byte {
+	toData := &wire.TrackerRegistrationRequest{
+		Registration: m.Registration,
+	}
+	by, err := proto.Marshal(toData)
+	if err != nil {
+		panic("Can't marshal RegistrationRequest.")
+	}
+	return by
+}
+
+func (m *RegistrationRequest) Type() string {
+	return wire.RegistrationCode
+}
+
+func (m *RegistrationRequest) Header() message.Header {
+	return m.h
+}
+
+// Query asks a tracker for the registration of an address or an alias, or
+// for the succession of an address.
+type Query struct {
+	Address    *identity.Address
+	Alias      string
+	Succession bool
+	h          message.Header
+}
+
+func CreateQuery(addr *identity.Address, from *identity.Address, tracker *identity.Address) *Query {
+	return &Query{
+		Address: addr,
+		h:       createHeader(from, tracker),
+	}
+}
+
+func CreateAliasQuery(alias string, from *identity.Address, tracker *identity.Address) *Query {
+	return &Query{
+		Alias: alias,
+		h:     createHeader(from, tracker),
+	}
+}
+
+func CreateSuccessionQuery(addr *identity.Address, from *identity.Address, tracker *identity.Address) *Query {
+	return &Query{
+		Address:    addr,
+		Succession: true,
+		h:          createHeader(from, tracker),
+	}
+}
+
+func CreateQueryFromBytes(by []byte, h message.Header) (*Query, error) {
+	unmarsh := &wire.TrackerQuery{}
+	err := proto.Unmarshal(by, unmarsh)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Query{
+		Alias:      unmarsh.GetAlias(),
+		Succession: unmarsh.GetSuccession(),
+		h:          h,
+	}
+	if len(unmarsh.GetAddress()) != 0 {
+		q.Address = identity.CreateAddressFromBytes(unmarsh.GetAddress())
+	}
+	return q, nil
+}
+
+func (m *Query) ToBytes() []byte {
+	toData := &wire.TrackerQuery{}
+	if m.Address != nil {
+		toData.Address = m.Address.Fingerprint
+	}
+	if m.Alias != "" {
+		toData.Alias = proto.String(m.Alias)
+	}
+	if m.Succession {
+		toData.Succession = proto.Bool(true)
+	}
+
+	by, err := proto.Marshal(toData)
+	if err != nil {
+		panic("Can't marshal Query.")
+	}
+	return by
+}
+
+func (m *Query) Type() string {
+	return wire.QueryCode
+}
+
+func (m *Query) Header() message.Header {
+	return m.h
+}
+
+// QueryResponse carries the signed registration that a tracker found for a
+// query (or that it stored for a registration request).
+type QueryResponse struct {
+	Registration []byte
+	h            message.Header
+}
+
+func CreateQueryResponse(signedRegistration []byte, from *identity.Address, to *identity.Address) *QueryResponse {
+	return &QueryResponse{
+		Registration: signedRegistration,
+		h:            message.CreateHeader(from, to),
+	}
+}
+
+func CreateQueryResponseFromBytes(by []byte, h message.Header) (*QueryResponse, error) {
+	unmarsh := &wire.TrackerQueryResponse{}
+	err := proto.Unmarshal(by, unmarsh)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QueryResponse{
+		Registration: unmarsh.GetRegistration(),
+		h:            h,
+	}, nil
+}
+
+func (m *QueryResponse) ToBytes() []byte {
+	toData := &wire.TrackerQueryResponse{
+		Registration: m.Registration,
+	}
+	by, err := proto.Marshal(toData)
+	if err != nil {
+		panic("Can't marshal QueryResponse.")
+	}
+	return by
+}
+
+func (m *QueryResponse) Type() string {
+	return wire.QueryResponseCode
+}
+
+func (m *QueryResponse) Header() message.Header {
+	return m.h
+}
+
+// SuccessionRequest asks a tracker to store a signed message.Succession.
+type SuccessionRequest struct {
+	Succession []byte
+	h          message.Header
+}
+
+func CreateSuccessionRequest(signedSuccession []byte, from *identity.Address, tracker *identity.Address) *SuccessionRequest {
+	return &SuccessionRequest{
+		Succession: signedSuccession,
+		h:          createHeader(from, tracker),
+	}
+}
+
+func CreateSuccessionRequestFromBytes(by []byte, h message.Header) (*SuccessionRequest, error) {
+	unmarsh := &wire.TrackerSuccession{}
+	err := proto.Unmarshal(by, unmarsh)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SuccessionRequest{
+		Succession: unmarsh.GetSuccession(),
+		h:          h,
+	}, nil
+}
+
+func (m *SuccessionRequest) ToBytes() []byte {
+	toData := &wire.TrackerSuccession{
+		Succession: m.Succession,
+	}
+	by, err := proto.Marshal(toData)
+	if err != nil {
+		panic("Can't marshal SuccessionRequest.")
+	}
+	return by
+}
+
+func (m *SuccessionRequest) Type() string {
+	return wire.SuccessionRequestCode
+}
+
+func (m *SuccessionRequest) Header() message.Header {
+	return m.h
+}
+
+// SuccessionResponse carries the signed succession that a tracker found for
+// a query (or that it stored for a succession request).
+type SuccessionResponse struct {
+	Succession []byte
+	h          message.Header
+}
+
+func CreateSuccessionResponse(signedSuccession []byte, from *identity.Address, to *identity.Address) *SuccessionResponse {
+	return &SuccessionResponse{
+		Succession: signedSuccession,
+		h:          message.CreateHeader(from, to),
+	}
+}
+
+func CreateSuccessionResponseFromBytes(by []byte, h message.Header) (*SuccessionResponse, error) {
+	unmarsh := &wire.TrackerSuccession{}
+	err := proto.Unmarshal(by, unmarsh)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SuccessionResponse{
+		Succession: unmarsh.GetSuccession(),
+		h:          h,
+	}, nil
+}
+
+func (m *SuccessionResponse) ToBytes() []byte {
+	toData := &wire.TrackerSuccession{
+		Succession: m.Succession,
+	}
+	by, err := proto.Marshal(toData)
+	if err != nil {
+		panic("Can't marshal SuccessionResponse.")
+	}
+	return by
+}
+
+func (m *SuccessionResponse) Type() string {
+	return wire.SuccessionResponseCode
+}
+
+func (m *SuccessionResponse) Header() message.Header {
+	return m.h
+}
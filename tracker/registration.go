@@ -0,0 +1,82 @@
+package tracker
+
+import (
+	"errors"
+	"time"
+
+	"airdispat.ch/crypto"
+	adErrors "airdispat.ch/errors"
+	"airdispat.ch/identity"
+	"airdispat.ch/message"
+	"airdispat.ch/wire"
+)
+
+// How long registrations made by a Router are valid for, unless the Router
+// has a Lifetime of its own.
+var DefaultRegistrationLifetime = 7 * 24 * time.Hour
+
+var ErrRegistrationExpired = errors.New("Registration has expired.")
+
+// SignRegistration signs a registration with the identity it registers, and
+// returns the bytes that trackers store and clients verify with
+// VerifyRegistration.
+func SignRegistration(reg *Registration, id *identity.Identity) ([]byte, error) {
+	for _, v := range reg.Redirects {
+		if v.Fingerprint == "" {
+			continue
+		} else if _, err := identity.CreateAddressFromString(v.Fingerprint); err != nil {
+			return nil, err
+		}
+	}
+
+	signed, err := message.SignMessage(reg, id)
+	if err != nil {
+		return nil, err
+	}
+
+	unencrypted, err := signed.UnencryptedMessage(identity.Public)
+	if err != nil {
+		return nil, err
+	}
+	return unencrypted.Data, nil
+}
+
+// VerifyRegistration checks that a signed registration was signed by the
+// address that it registers, and that it has not expired.
+//
+// Returns adErrors.ADTrackerVerificationError if the signature or keys of
+// the registration don't match its address.
+func VerifyRegistration(by []byte) (*Registration, error) {
+	signed, err := (&message.EncryptedMessage{Data: by}).UnencryptedMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	if !signed.Verify() {
+		return nil, adErrors.ADTrackerVerificationError
+	}
+
+	data, typ, h, err := signed.ReconstructMessage()
+	if err != nil {
+		return nil, adErrors.ADTrackerVerificationError
+	} else if typ != wire.RegistrationRecordCode {
+		return nil, adErrors.ADUnexpectedMessageTypeError
+	}
+
+	reg, err := CreateRegistrationFromBytes(data, h)
+	if err != nil {
+		return nil, err
+	}
+
+	// The address must be the fingerprint of the signing key that signed it.
+	if !h.From.EqualsBytes(reg.Address.Fingerprint) ||
+		!reg.Address.EqualsBytes(crypto.BytesToAddress(crypto.SigningKeyToBytes(reg.Address.SigningKey))) {
+		return nil, adErrors.ADTrackerVerificationError
+	}
+
+	if time.Now().After(reg.Expires) {
+		return nil, ErrRegistrationExpired
+	}
+
+	return reg, nil
+}
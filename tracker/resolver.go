@@ -0,0 +1,245 @@
+package tracker
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"airdispat.ch/identity"
+	"airdispat.ch/routing"
+)
+
+// TrackerRecordPrefix starts the TXT records that list the trackers of a
+// domain. The records are published at TrackerRecordName + domain.
+const (
+	TrackerRecordPrefix = "adtracker="
+	TrackerRecordName   = "_airdispatch."
+)
+
+var ErrNoTrackers = errors.New("No trackers are known for that domain.")
+
+// EncodeTrackerRecord returns the text that describes a tracker in a
+// resolver configuration file or a TXT record. The address must have a
+// location and keys.
+func EncodeTrackerRecord(tracker *identity.Address) (string, error) {
+	by, err := tracker.Encode()
+	if err != nil {
+		return "", err
+	}
+	return TrackerRecordPrefix + base64.StdEncoding.EncodeToString(by), nil
+}
+
+// DecodeTrackerRecord returns the tracker described by the text of a record.
+func DecodeTrackerRecord(record string) (*identity.Address, error) {
+	if !strings.HasPrefix(record, TrackerRecordPrefix) {
+		return nil, errors.New("Not a tracker record.")
+	}
+
+	by, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(record, TrackerRecordPrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	tracker, err := identity.DecodeAddress(by)
+	if err != nil {
+		return nil, err
+	}
+
+	if tracker.Location == "" {
+		return nil, errors.New("Tracker record has no location.")
+	}
+	return tracker, nil
+}
+
+// DomainResolver finds the trackers that are authoritative for a domain,
+// first from the Domains it was configured with, and then from the TXT
+// records of the domain (if LookupTXT is set).
+type DomainResolver struct {
+	Domains   map[string][]*identity.Address
+	LookupTXT func(name string) ([]string, error)
+
+	lock sync.RWMutex
+}
+
+// CreateDomainResolver returns a resolver that only uses DNS.
+func CreateDomainResolver() *DomainResolver {
+	return &DomainResolver{
+		Domains:   make(map[string][]*identity.Address),
+		LookupTXT: net.LookupTXT,
+	}
+}
+
+// LoadDomainResolver reads a resolver configuration file. Each line holds a
+// domain followed by tracker records (see EncodeTrackerRecord). Blank lines
+// and lines starting with # are ignored. The resolver doesn't use DNS unless
+// LookupTXT is set.
+func LoadDomainResolver(filename string) (*DomainResolver, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	d := &DomainResolver{
+		Domains: make(map[string][]*identity.Address),
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64*1024)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		} else if len(fields) < 2 {
+			return nil, errors.New("Resolver configuration line has no trackers: " + scanner.Text())
+		}
+
+		domain := strings.ToLower(fields[0])
+		for _, v := range fields[1:] {
+			tracker, err := DecodeTrackerRecord(v)
+			if err != nil {
+				return nil, err
+			}
+			d.Domains[domain] = append(d.Domains[domain], tracker)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// AddTracker makes a tracker authoritative for a domain.
+func (d *DomainResolver) AddTracker(domain string, tracker *identity.Address) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.Domains == nil {
+		d.Domains = make(map[string][]*identity.Address)
+	}
+	domain = strings.ToLower(domain)
+	d.Domains[domain] = append(d.Domains[domain], tracker)
+}
+
+// Trackers returns the trackers that are authoritative for a domain.
+func (d *DomainResolver) Trackers(domain string) ([]*identity.Address, error) {
+	domain = strings.ToLower(domain)
+
+	d.lock.RLock()
+	trackers := d.Domains[domain]
+	d.lock.RUnlock()
+
+	if len(trackers) != 0 {
+		return trackers, nil
+	} else if d.LookupTXT == nil {
+		return nil, ErrNoTrackers
+	}
+
+	records, err := d.LookupTXT(TrackerRecordName + domain)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range records {
+		if !strings.HasPrefix(v, TrackerRecordPrefix) {
+			continue
+		}
+
+		tracker, err := DecodeTrackerRecord(v)
+		if err != nil {
+			return nil, err
+		}
+		trackers = append(trackers, tracker)
+	}
+
+	if len(trackers) == 0 {
+		return nil, ErrNoTrackers
+	}
+	return trackers, nil
+}
+
+// AliasRouter looks up aliases of the form user@domain with the trackers
+// that are authoritative for the domain. Everything else is passed on to
+// Router, if it is set.
+type AliasRouter struct {
+	Origin   *identity.Identity
+	Resolver *DomainResolver
+	Router   routing.Router
+}
+
+func CreateAliasRouter(origin *identity.Identity, resolver *DomainResolver, fallback routing.Router) *AliasRouter {
+	return &AliasRouter{
+		Origin:   origin,
+		Resolver: resolver,
+		Router:   fallback,
+	}
+}
+
+// Returns the router for the domain of an alias, along with the normalized
+// alias
+func (a *AliasRouter) routerForAlias(alias string) (routing.Router, string, error) {
+	alias = normalizeAlias(alias)
+	_, domain, err := routing.SplitAlias(alias)
+	if err != nil {
+		return nil, "", err
+	}
+
+	trackers, err := a.Resolver.Trackers(domain)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(trackers) == 1 {
+		return CreateRouter(a.Origin, trackers[0]), alias, nil
+	}
+
+	r, err := CreateTrackerListRouter(a.Origin, 0, trackers...)
+	if err != nil {
+		return nil, "", err
+	}
+	return r, alias, nil
+}
+
+// Register registers the identity with the trackers of its alias's domain,
+// or with Router for any other alias.
+func (a *AliasRouter) Register(id *identity.Identity, alias string, redirects map[string]routing.Redirect) error {
+	if !routing.IsDomainAlias(normalizeAlias(alias)) {
+		if a.Router == nil {
+			return routing.ErrInvalidAlias
+		}
+		return a.Router.Register(id, alias, redirects)
+	}
+
+	r, alias, err := a.routerForAlias(alias)
+	if err != nil {
+		return err
+	}
+	return r.Register(id, alias, redirects)
+}
+
+func (a *AliasRouter) Lookup(addr string, name routing.LookupType) (*identity.Address, error) {
+	if a.Router == nil {
+		return nil, routing.ErrAddressNotFound
+	}
+	return a.Router.Lookup(addr, name)
+}
+
+func (a *AliasRouter) LookupAlias(alias string, name routing.LookupType) (*identity.Address, error) {
+	if !routing.IsDomainAlias(normalizeAlias(alias)) {
+		if a.Router == nil {
+			return nil, routing.ErrInvalidAlias
+		}
+		return a.Router.LookupAlias(alias, name)
+	}
+
+	r, alias, err := a.routerForAlias(alias)
+	if err != nil {
+		return nil, err
+	}
+	return r.LookupAlias(alias, name)
+}
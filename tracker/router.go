@@ -0,0 +1,216 @@
+package tracker
+
+import (
+	"errors"
+	"time"
+
+	adErrors "airdispat.ch/errors"
+	"airdispat.ch/identity"
+	"airdispat.ch/message"
+	"airdispat.ch/routing"
+	"airdispat.ch/wire"
+)
+
+// Router is a routing.Router that registers and looks up addresses with a
+// tracker.
+type Router struct {
+	// The identity that queries are sent from.
+	Origin *identity.Identity
+	// The tracker, which must have a location and an encryption key.
+	Tracker *identity.Address
+	// How long registrations are valid for. If zero,
+	// DefaultRegistrationLifetime is used.
+	Lifetime time.Duration
+}
+
+func CreateRouter(origin *identity.Identity, tracker *identity.Address) *Router {
+	return &Router{
+		Origin:  origin,
+		Tracker: tracker,
+	}
+}
+
+// Register publishes the address of id with the tracker. The location of the
+// address must be set.
+func (r *Router) Register(id *identity.Identity, alias string, redirects map[string]routing.Redirect) error {
+	if id.Address.Location == "" {
+		return errors.New("Can't register an address without a location.")
+	}
+
+	lifetime := r.Lifetime
+	if lifetime == 0 {
+		lifetime = DefaultRegistrationLifetime
+	}
+
+	signed, err := SignRegistration(CreateRegistration(id, alias, redirects, lifetime), id)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.send(CreateRegistrationRequest(signed, id.Address, r.Tracker), id)
+	return err
+}
+
+// Lookup finds the address on the tracker. If it has redirects for name,
+// they are followed (see routing.Resolve).
+func (r *Router) Lookup(addr string, name routing.LookupType) (*identity.Address, error) {
+	found, _, err := r.LookupWithExpiry(addr, name)
+	return found, err
+}
+
+// LookupAlias finds the address registered for an alias on the tracker. If
+// it has redirects for name, they are followed (see routing.Resolve).
+func (r *Router) LookupAlias(alias string, name routing.LookupType) (*identity.Address, error) {
+	found, _, err := r.LookupAliasWithExpiry(alias, name)
+	return found, err
+}
+
+// LookupWithExpiry does the same as Lookup, but also returns when the first
+// of the registrations used to find the address expires.
+func (r *Router) LookupWithExpiry(addr string, name routing.LookupType) (*identity.Address, time.Time, error) {
+	reg, err := r.lookup(addr)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return r.followRedirect(reg, name)
+}
+
+// LookupAliasWithExpiry does the same as LookupAlias, but also returns when
+// the first of the registrations used to find the address expires.
+func (r *Router) LookupAliasWithExpiry(alias string, name routing.LookupType) (*identity.Address, time.Time, error) {
+	reg, err := r.lookupAlias(alias)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return r.followRedirect(reg, name)
+}
+
+func (r *Router) lookup(addr string) (*Registration, error) {
+	query, err := identity.CreateAddressFromString(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	reg, err := r.send(CreateQuery(query, r.Origin.Address, r.Tracker), r.Origin)
+	if err != nil {
+		return nil, err
+	}
+
+	if !reg.Address.EqualsBytes(query.Fingerprint) {
+		return nil, adErrors.ADTrackerVerificationError
+	}
+	return reg, nil
+}
+
+func (r *Router) lookupAlias(alias string) (*Registration, error) {
+	reg, err := r.send(CreateAliasQuery(alias, r.Origin.Address, r.Tracker), r.Origin)
+	if err != nil {
+		return nil, err
+	}
+
+	if normalizeAlias(reg.Address.Alias) != normalizeAlias(alias) {
+		return nil, adErrors.ADTrackerVerificationError
+	}
+	return reg, nil
+}
+
+// Follows the redirects of a registration for a LookupType
+func (r *Router) followRedirect(reg *Registration, name routing.LookupType) (*identity.Address, time.Time, error) {
+	expires := reg.Expires
+	found, err := routing.Resolve(reg.Address, reg.Redirects, name, func(redirect routing.Redirect) (*identity.Address, map[string]routing.Redirect, error) {
+		target, err := r.lookupRedirect(redirect)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if target.Expires.Before(expires) {
+			expires = target.Expires
+		}
+		return target.Address, target.Redirects, nil
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return found, expires, nil
+}
+
+func (r *Router) lookupRedirect(redirect routing.Redirect) (*Registration, error) {
+	if redirect.Fingerprint != "" {
+		return r.lookup(redirect.Fingerprint)
+	}
+	return r.lookupAlias(redirect.Alias)
+}
+
+// RegisterSuccession stores a signed succession (see message.SignSuccession)
+// with the tracker.
+func (r *Router) RegisterSuccession(signed []byte) error {
+	data, h, err := r.exchange(CreateSuccessionRequest(signed, r.Origin.Address, r.Tracker), r.Origin, wire.SuccessionResponseCode)
+	if err != nil {
+		return err
+	}
+
+	_, err = CreateSuccessionResponseFromBytes(data, h)
+	return err
+}
+
+// LookupSuccession returns the signed succession of an address from the
+// tracker, once its signature has been verified.
+func (r *Router) LookupSuccession(addr string) ([]byte, error) {
+	query, err := identity.CreateAddressFromString(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	data, h, err := r.exchange(CreateSuccessionQuery(query, r.Origin.Address, r.Tracker), r.Origin, wire.SuccessionResponseCode)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := CreateSuccessionResponseFromBytes(data, h)
+	if err != nil {
+		return nil, err
+	}
+
+	succ, err := message.VerifySuccession(resp.Succession)
+	if err != nil {
+		return nil, err
+	} else if !succ.Address.EqualsBytes(query.Fingerprint) {
+		return nil, adErrors.ADTrackerVerificationError
+	}
+	return resp.Succession, nil
+}
+
+// Sends a message to the tracker and returns the registration it responds
+// with, once the signature of the registration has been verified
+func (r *Router) send(m message.Message, from *identity.Identity) (*Registration, error) {
+	data, h, err := r.exchange(m, from, wire.QueryResponseCode)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := CreateQueryResponseFromBytes(data, h)
+	if err != nil {
+		return nil, err
+	}
+	return VerifyRegistration(resp.Registration)
+}
+
+// Sends a message to the tracker and returns its response, which must come
+// from the tracker and be of type typ
+func (r *Router) exchange(m message.Message, from *identity.Identity, typ string) ([]byte, message.Header, error) {
+	data, respType, h, err := message.SendMessageAndReceiveWithTimestamp(m, from, r.Tracker)
+	if err != nil {
+		return nil, message.Header{}, err
+	}
+
+	if !r.Tracker.EqualsBytes(h.From.Fingerprint) {
+		return nil, message.Header{}, adErrors.ADTrackerVerificationError
+	}
+
+	if respType == wire.ErrorCode {
+		return nil, message.Header{}, adErrors.CreateErrorFromBytes(data, h)
+	} else if respType != typ {
+		return nil, message.Header{}, adErrors.ADUnexpectedMessageTypeError
+	}
+	return data, h, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"airdispat.ch/identity"
+	"airdispat.ch/message"
+	"airdispat.ch/server"
+	"airdispat.ch/tracker"
+	"context"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Configuration Varables
+var port = flag.String("port", "1024", "select the port on which to run the tracker")
+var me = flag.String("me", "", "the location of the tracker that clients connect to")
+var key_file = flag.String("key", "", "the file to store keys")
+var address_file = flag.String("address", "", "the file to write the public address of the tracker to, for clients to load with identity.DecodeAddress")
+var key_passphrase = flag.String("passphrase-env", "AIRDISPATCH_KEY_PASSPHRASE", "the environment variable holding the passphrase used to encrypt the key file")
+var domain = flag.String("domain", "", "the domain the tracker is authoritative for, if it should only register aliases of the form user@domain")
+var shutdown_timeout = flag.Duration("shutdown-timeout", 30*time.Second, "how long to wait for clients to finish when shutting down")
+
+// trackerDelegate logs like a BasicServer but refuses to store mail.
+type trackerDelegate struct {
+	server.BasicServer
+}
+
+func (trackerDelegate) SaveMessageDescription(desc *message.EncryptedMessage, forAddr *identity.Address) {
+}
+
+func (trackerDelegate) RetrieveDataForUser(id string, author *identity.Address, forAddr *identity.Address) (*message.EncryptedMessage, io.ReadCloser) {
+	return nil, nil
+}
+
+func (trackerDelegate) RetrieveMessageForUser(id string, author *identity.Address, forAddr *identity.Address) *message.EncryptedMessage {
+	return nil
+}
+
+func (trackerDelegate) RetrieveMessageListForUser(since uint64, author *identity.Address, forAddr *identity.Address) []*message.EncryptedMessage {
+	return nil
+}
+
+func main() {
+	// Parse the configuration Command Line Falgs
+	flag.Parse()
+
+	handler := trackerDelegate{}
+
+	// Load or Create the Key for the Tracker
+	passphrase := os.Getenv(*key_passphrase)
+	key, err := loadTrackerKey(*key_file, passphrase)
+	if os.IsNotExist(err) {
+
+		key, err = identity.CreateIdentity()
+		if err != nil {
+			handler.HandleError(&server.ServerError{Location: "Creating Tracker Key", Error: err})
+			return
+		}
+
+		if *key_file != "" {
+			if passphrase == "" {
+				err = key.SaveKeyToFile(*key_file)
+			} else {
+				err = key.SaveKeyToFileWithPassphrase(*key_file, passphrase)
+			}
+			if err != nil {
+				handler.HandleError(&server.ServerError{Location: "Saving Tracker Key", Error: err})
+				return
+			}
+		}
+
+	} else if err != nil {
+		handler.HandleError(&server.ServerError{Location: "Loading Tracker Key", Error: err})
+		return
+	}
+	key.SetLocation(*me)
+	handler.LogMessage("Loaded Address", key.Address.String())
+
+	// Publish the Address of the Tracker for Clients
+	if *address_file != "" {
+		by, err := key.Address.Encode()
+		if err == nil {
+			err = ioutil.WriteFile(*address_file, by, 0644)
+		}
+		if err != nil {
+			handler.HandleError(&server.ServerError{Location: "Writing Tracker Address", Error: err})
+			return
+		}
+	}
+
+	// Log the Record that Names the Tracker for its Domain
+	trackerHandler := tracker.CreateHandler(key)
+	if *domain != "" {
+		trackerHandler.Domain = *domain
+
+		record, err := tracker.EncodeTrackerRecord(key.Address)
+		if err != nil {
+			handler.HandleError(&server.ServerError{Location: "Encoding Tracker Record", Error: err})
+			return
+		}
+		handler.LogMessage("Publish TXT Record", tracker.TrackerRecordName+*domain, record)
+	}
+
+	theServer := &server.Server{
+		LocationName: *me,
+		Key:          key,
+		Delegate:     handler,
+		Handlers:     []server.Handler{trackerHandler},
+		ReadTimeout:  time.Minute,
+		WriteTimeout: time.Minute,
+	}
+
+	// Shutdown gracefully when interrupted
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		<-interrupt
+		handler.LogMessage("Shutting down Tracker")
+
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdown_timeout)
+		defer cancel()
+		shutdown <- theServer.Shutdown(ctx)
+	}()
+
+	err = theServer.StartServer(*port)
+	if err != server.ErrServerClosed {
+		handler.HandleError(&server.ServerError{Location: "Starting Tracker", Error: err})
+		os.Exit(1)
+	}
+
+	err = <-shutdown
+	if err != nil {
+		handler.HandleError(&server.ServerError{Location: "Shutting down Tracker", Error: err})
+	}
+}
+
+// Loads the tracker key, encrypting legacy key files in place if a
+// passphrase has been provided.
+func loadTrackerKey(filename string, passphrase string) (*identity.Identity, error) {
+	if passphrase == "" {
+		return identity.LoadKeyFromFile(filename)
+	}
+
+	err := identity.MigrateKeyFile(filename, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return identity.LoadKeyFromFileWithPassphrase(filename, passphrase)
+}
@@ -0,0 +1,333 @@
+package tracker
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	adErrors "airdispat.ch/errors"
+	"airdispat.ch/identity"
+	"airdispat.ch/message"
+	"airdispat.ch/routing"
+	"airdispat.ch/server"
+)
+
+// Starts a tracker on a random port, returning its address
+func startTracker(t *testing.T) *identity.Address {
+	key, err := identity.CreateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.SetLocation(listener.Addr().String())
+
+	s := &server.Server{
+		LocationName: key.Address.Location,
+		Key:          key,
+		Delegate:     server.BasicServer{},
+		Handlers:     []server.Handler{CreateHandler(key)},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.Serve(ctx, listener)
+
+	return key.Address
+}
+
+func createLocatedIdentity(t *testing.T, location string) *identity.Identity {
+	id, err := identity.CreateIdentityOfKind(identity.KindCurve25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id.SetLocation(location)
+	return id
+}
+
+func TestTrackerRouter(t *testing.T) {
+	tracker := startTracker(t)
+
+	alice := createLocatedIdentity(t, "alice.example:2048")
+	alerts := createLocatedIdentity(t, "alerts.example:2048")
+	client := createLocatedIdentity(t, "client.example:2048")
+
+	router := CreateRouter(client, tracker)
+
+	err := router.Register(alerts, "alerts", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = router.Register(alice, "alice", map[string]routing.Redirect{
+		string(routing.LookupTypeALERT): {
+			Type:        routing.LookupTypeALERT,
+			Fingerprint: alerts.Address.String(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := router.Lookup(alice.Address.String(), routing.LookupTypeDEFAULT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Location != alice.Address.Location || found.String() != alice.Address.String() || !found.CanSend() {
+		t.Error("Lookup returned the wrong address.", found)
+	}
+
+	found, err = router.LookupAlias("alice", routing.LookupTypeMAIL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.String() != alice.Address.String() {
+		t.Error("Alias lookup returned the wrong address.", found)
+	}
+
+	found, err = router.Lookup(alice.Address.String(), routing.LookupTypeALERT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.String() != alerts.Address.String() || found.Location != alerts.Address.Location {
+		t.Error("Lookup did not follow the alert redirect.", found)
+	}
+
+	// Unknown addresses and taken aliases are reported with error codes.
+	_, err = router.Lookup(client.Address.String(), routing.LookupTypeDEFAULT)
+	if e, ok := err.(*adErrors.Error); !ok || e.Code != uint32(adErrors.AddressNotFound) {
+		t.Error("Expected AddressNotFound, got", err)
+	}
+
+	err = router.Register(client, "alice", nil)
+	if e, ok := err.(*adErrors.Error); !ok || e.Code != uint32(adErrors.NotAuthorized) {
+		t.Error("Expected NotAuthorized for a taken alias, got", err)
+	}
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	alice := createLocatedIdentity(t, "alice.example:2048")
+	mallory := createLocatedIdentity(t, "mallory.example:2048")
+
+	signed, err := SignRegistration(CreateRegistration(alice, "alice", nil, time.Hour), alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg, err := VerifyRegistration(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reg.Address.EqualsBytes(alice.Address.Fingerprint) || reg.Address.Alias != "alice" {
+		t.Error("Registration did not survive signing.", reg.Address)
+	}
+
+	// A registration for alice with mallory's encryption key, signed by mallory.
+	forged := CreateRegistration(alice, "alice", nil, time.Hour)
+	forged.Address.EncryptionKey = mallory.Address.EncryptionKey
+	forged.h = message.CreateHeader(mallory.Address)
+	signed, err = SignRegistration(forged, mallory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyRegistration(signed); err != adErrors.ADTrackerVerificationError {
+		t.Error("Expected forged registration to fail verification, got", err)
+	}
+
+	// A registration claiming alice's fingerprint for mallory's keys.
+	forged = CreateRegistration(mallory, "alice", nil, time.Hour)
+	forged.Address.Fingerprint = alice.Address.Fingerprint
+	signed, err = SignRegistration(forged, mallory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyRegistration(signed); err != adErrors.ADTrackerVerificationError {
+		t.Error("Expected mismatched fingerprint to fail verification, got", err)
+	}
+
+	signed, err = SignRegistration(CreateRegistration(alice, "alice", nil, -time.Minute), alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyRegistration(signed); err != ErrRegistrationExpired {
+		t.Error("Expected expired registration to be rejected, got", err)
+	}
+}
+
+// Answers every lookup with the same address
+type fixedRouter struct {
+	addr *identity.Address
+}
+
+func (r fixedRouter) Register(*identity.Identity, string, map[string]routing.Redirect) error {
+	return nil
+}
+
+func (r fixedRouter) Lookup(addr string, name routing.LookupType) (*identity.Address, error) {
+	if r.addr == nil {
+		return nil, routing.ErrAddressNotFound
+	}
+	return r.addr, nil
+}
+
+func (r fixedRouter) LookupAlias(alias string, name routing.LookupType) (*identity.Address, error) {
+	return r.Lookup(alias, name)
+}
+
+func TestFederatedRouter(t *testing.T) {
+	alice := createLocatedIdentity(t, "alice.example:2048")
+	mallory := createLocatedIdentity(t, "mallory.example:2048")
+
+	// A tracker that substitutes mallory's key and location for alice's.
+	substituted := *alice.Address
+	substituted.EncryptionKey = mallory.Address.EncryptionKey
+	substituted.Location = mallory.Address.Location
+
+	honest := fixedRouter{alice.Address}
+	substituting := fixedRouter{&substituted}
+	routers := []routing.Router{honest, substituting, honest}
+
+	federated := func(quorum int, routers ...routing.Router) *FederatedRouter {
+		f, err := CreateFederatedRouter(quorum, routers...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	found, err := federated(0, routers...).Lookup(alice.Address.String(), routing.LookupTypeDEFAULT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Location != alice.Address.Location {
+		t.Error("Expected the majority to win, got", found.Location)
+	}
+
+	_, err = federated(3, routers...).Lookup(alice.Address.String(), routing.LookupTypeDEFAULT)
+	if err != adErrors.ADTrackerVerificationError {
+		t.Error("Expected disagreement to fail verification, got", err)
+	}
+
+	_, err = federated(2, honest, fixedRouter{}).Lookup(alice.Address.String(), routing.LookupTypeDEFAULT)
+	if err != adErrors.ADTrackerVerificationError {
+		t.Error("Expected a missing registration to count as disagreement, got", err)
+	}
+
+	_, err = federated(1, fixedRouter{}, fixedRouter{}).Lookup(alice.Address.String(), routing.LookupTypeDEFAULT)
+	if err != adErrors.ADTrackerListQueryError {
+		t.Error("Expected unknown address to fail the list query, got", err)
+	}
+
+	// Both trackers reach a quorum of one, but they disagree.
+	for i := 0; i < 10; i++ {
+		_, err = federated(1, honest, substituting).Lookup(alice.Address.String(), routing.LookupTypeDEFAULT)
+		if err != adErrors.ADTrackerVerificationError {
+			t.Fatal("Expected disagreeing trackers to fail verification, got", err)
+		}
+	}
+
+	for _, quorum := range []int{-1, 3} {
+		if _, err := CreateFederatedRouter(quorum, honest, honest); err != ErrInvalidQuorum {
+			t.Error("Expected quorum", quorum, "to be rejected, got", err)
+		}
+	}
+}
+
+func TestAliasRouter(t *testing.T) {
+	tracker := startTracker(t)
+	record, err := EncodeTrackerRecord(tracker)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := &DomainResolver{
+		LookupTXT: func(name string) ([]string, error) {
+			if name != "_airdispatch.example.com" {
+				return nil, nil
+			}
+			return []string{"v=spf1 -all", record}, nil
+		},
+	}
+
+	alice := createLocatedIdentity(t, "alice.example:2048")
+	client := createLocatedIdentity(t, "client.example:2048")
+	router := CreateAliasRouter(client, resolver, nil)
+
+	if err := router.Register(alice, "alice@Example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := router.LookupAlias("alice@example.com", routing.LookupTypeDEFAULT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.String() != alice.Address.String() || found.Location != alice.Address.Location {
+		t.Error("Expected to find alice, got", found)
+	}
+
+	if _, err := router.LookupAlias("bob@example.org", routing.LookupTypeDEFAULT); err != ErrNoTrackers {
+		t.Error("Expected ErrNoTrackers for unknown domain, got", err)
+	}
+	if _, err := router.LookupAlias("alice", routing.LookupTypeDEFAULT); err != routing.ErrInvalidAlias {
+		t.Error("Expected ErrInvalidAlias without a fallback router, got", err)
+	}
+
+	// Aliases registered directly with the tracker match regardless of case.
+	carol := createLocatedIdentity(t, "carol.example:2048")
+	if err := CreateRouter(client, tracker).Register(carol, " Carol@Example.COM", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, alias := range []string{"carol@example.com", "CAROL@example.com "} {
+		found, err := router.LookupAlias(alias, routing.LookupTypeDEFAULT)
+		if err != nil {
+			t.Fatal(alias, err)
+		}
+		if found.String() != carol.Address.String() {
+			t.Error("Expected to find carol for", alias, "got", found)
+		}
+	}
+}
+
+func TestTrackerSuccession(t *testing.T) {
+	tracker := startTracker(t)
+
+	alice := createLocatedIdentity(t, "alice.example:2048")
+	successor := createLocatedIdentity(t, "alice.example:2048")
+	mallory := createLocatedIdentity(t, "mallory.example:2048")
+	client := createLocatedIdentity(t, "client.example:2048")
+
+	router := CreateRouter(client, tracker)
+
+	if _, err := message.LookupSuccession(router, alice.Address.String()); !routing.IsNotFound(err) {
+		t.Error("Expected no succession before one is registered, got", err)
+	}
+
+	by, err := message.SignSuccession(alice, successor.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := router.RegisterSuccession(by); err != nil {
+		t.Fatal(err)
+	}
+
+	succ, err := message.LookupSuccession(router, alice.Address.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !succ.Successor.EqualsBytes(successor.Address.Fingerprint) {
+		t.Error("Expected successor, got", succ.Successor)
+	}
+
+	// The address can't be handed over again to someone else.
+	by, err = message.SignSuccession(alice, mallory.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := router.RegisterSuccession(by); err == nil {
+		t.Error("Expected a second successor to be rejected.")
+	}
+}
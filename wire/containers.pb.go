@@ -19,6 +19,7 @@ type Header struct {
 	Timestamp        *uint64  `protobuf:"varint,3,req,name=timestamp" json:"timestamp,omitempty"`
 	Alias            *string  `protobuf:"bytes,4,opt,name=alias" json:"alias,omitempty"`
 	EncryptionKey    []byte   `protobuf:"bytes,5,opt,name=encryption_key" json:"encryption_key,omitempty"`
+	Nonce            *uint64  `protobuf:"varint,6,opt,name=nonce" json:"nonce,omitempty"`
 	XXX_unrecognized []byte   `json:"-"`
 }
 
@@ -61,6 +62,13 @@ func (m *Header) GetEncryptionKey() []byte {
 	return nil
 }
 
+func (m *Header) GetNonce() uint64 {
+	if m != nil && m.Nonce != nil {
+		return *m.Nonce
+	}
+	return 0
+}
+
 type SignedMessage struct {
 	Data             []byte       `protobuf:"bytes,1,req,name=data" json:"data,omitempty"`
 	Signature        []*Signature `protobuf:"bytes,2,rep,name=signature" json:"signature,omitempty"`
@@ -125,6 +133,38 @@ func (m *Signature) GetSigningKey() []byte {
 	return nil
 }
 
+type DetachedSignature struct {
+	Signature        *Signature `protobuf:"bytes,1,req,name=signature" json:"signature,omitempty"`
+	SigningFunc      []byte     `protobuf:"bytes,2,req,name=signing_func" json:"signing_func,omitempty"`
+	Timestamp        *uint64    `protobuf:"varint,3,req,name=timestamp" json:"timestamp,omitempty"`
+	XXX_unrecognized []byte     `json:"-"`
+}
+
+func (m *DetachedSignature) Reset()         { *m = DetachedSignature{} }
+func (m *DetachedSignature) String() string { return proto.CompactTextString(m) }
+func (*DetachedSignature) ProtoMessage()    {}
+
+func (m *DetachedSignature) GetSignature() *Signature {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+func (m *DetachedSignature) GetSigningFunc() []byte {
+	if m != nil {
+		return m.SigningFunc
+	}
+	return nil
+}
+
+func (m *DetachedSignature) GetTimestamp() uint64 {
+	if m != nil && m.Timestamp != nil {
+		return *m.Timestamp
+	}
+	return 0
+}
+
 type EncryptedMessage struct {
 	Data             []byte             `protobuf:"bytes,1,req,name=data" json:"data,omitempty"`
 	Header           []*EncryptedHeader `protobuf:"bytes,2,rep,name=header" json:"header,omitempty"`
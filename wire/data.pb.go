@@ -72,6 +72,9 @@ func (m *Data) GetFile() string {
 type Mail struct {
 	Components       []*Mail_Component `protobuf:"bytes,1,rep,name=components" json:"components,omitempty"`
 	Name             *string           `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
+	InReplyTo        *Mail_Reference   `protobuf:"bytes,3,opt,name=in_reply_to" json:"in_reply_to,omitempty"`
+	Thread           *Mail_Reference   `protobuf:"bytes,4,opt,name=thread" json:"thread,omitempty"`
+	References       []*Mail_Reference `protobuf:"bytes,5,rep,name=references" json:"references,omitempty"`
 	XXX_unrecognized []byte            `json:"-"`
 }
 
@@ -93,6 +96,27 @@ func (m *Mail) GetName() string {
 	return ""
 }
 
+func (m *Mail) GetInReplyTo() *Mail_Reference {
+	if m != nil {
+		return m.InReplyTo
+	}
+	return nil
+}
+
+func (m *Mail) GetThread() *Mail_Reference {
+	if m != nil {
+		return m.Thread
+	}
+	return nil
+}
+
+func (m *Mail) GetReferences() []*Mail_Reference {
+	if m != nil {
+		return m.References
+	}
+	return nil
+}
+
 type Mail_Component struct {
 	Type             *string `protobuf:"bytes,1,req,name=type" json:"type,omitempty"`
 	Data             []byte  `protobuf:"bytes,2,req,name=data" json:"data,omitempty"`
@@ -117,6 +141,30 @@ func (m *Mail_Component) GetData() []byte {
 	return nil
 }
 
+type Mail_Reference struct {
+	Author           []byte  `protobuf:"bytes,1,req,name=author" json:"author,omitempty"`
+	Name             *string `protobuf:"bytes,2,req,name=name" json:"name,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *Mail_Reference) Reset()         { *m = Mail_Reference{} }
+func (m *Mail_Reference) String() string { return proto.CompactTextString(m) }
+func (*Mail_Reference) ProtoMessage()    {}
+
+func (m *Mail_Reference) GetAuthor() []byte {
+	if m != nil {
+		return m.Author
+	}
+	return nil
+}
+
+func (m *Mail_Reference) GetName() string {
+	if m != nil && m.Name != nil {
+		return *m.Name
+	}
+	return ""
+}
+
 type Error struct {
 	Code             *uint32 `protobuf:"varint,1,req,name=code" json:"code,omitempty"`
 	Description      *string `protobuf:"bytes,2,opt,name=description" json:"description,omitempty"`
@@ -141,5 +189,45 @@ func (m *Error) GetDescription() string {
 	return ""
 }
 
+type KeySuccession struct {
+	Address          []byte `protobuf:"bytes,1,req,name=address" json:"address,omitempty"`
+	Successor        []byte `protobuf:"bytes,2,req,name=successor" json:"successor,omitempty"`
+	EncryptionKey    []byte `protobuf:"bytes,3,req,name=encryption_key" json:"encryption_key,omitempty"`
+	SigningKey       []byte `protobuf:"bytes,4,req,name=signing_key" json:"signing_key,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *KeySuccession) Reset()         { *m = KeySuccession{} }
+func (m *KeySuccession) String() string { return proto.CompactTextString(m) }
+func (*KeySuccession) ProtoMessage()    {}
+
+func (m *KeySuccession) GetAddress() []byte {
+	if m != nil {
+		return m.Address
+	}
+	return nil
+}
+
+func (m *KeySuccession) GetSuccessor() []byte {
+	if m != nil {
+		return m.Successor
+	}
+	return nil
+}
+
+func (m *KeySuccession) GetEncryptionKey() []byte {
+	if m != nil {
+		return m.EncryptionKey
+	}
+	return nil
+}
+
+func (m *KeySuccession) GetSigningKey() []byte {
+	if m != nil {
+		return m.SigningKey
+	}
+	return nil
+}
+
 func init() {
 }
@@ -0,0 +1,147 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// DefaultMaxFrameSize is the largest frame that ReadBytes and a new
+// FrameReader will accept. It may be changed to suit the application.
+var DefaultMaxFrameSize int32 = 32 << 20
+
+// frameChunkSize is the most that is allocated for a frame before its data
+// has actually arrived.
+const frameChunkSize = 64 << 10
+
+var ErrInvalidPrefix = errors.New("Message is not for airdispatch...")
+var ErrEmptyFrame = errors.New("Cannot read a message with no content.")
+
+// FrameSizeError is returned when the length prefix of a frame is negative or
+// larger than the maximum frame size.
+type FrameSizeError struct {
+	Length int64
+	Max    int32
+}
+
+func (e *FrameSizeError) Error() string {
+	if e.Length < 0 {
+		return fmt.Sprintf("Frame has a negative length (%d).", e.Length)
+	}
+	return fmt.Sprintf("Frame length %d is larger than the maximum of %d.", e.Length, e.Max)
+}
+
+// A FrameReader reads length-prefixed AirDispatch frames from a stream, one
+// at a time. It never reads past the end of a frame, so the underlying
+// connection may be used directly between frames.
+type FrameReader struct {
+	// The largest frame (in bytes) that will be accepted.
+	MaxFrameSize int32
+	// If non-zero, and the reader has a SetReadDeadline method, each
+	// frame must be read within Timeout.
+	Timeout time.Duration
+
+	r io.Reader
+}
+
+// NewFrameReader creates a FrameReader with the DefaultMaxFrameSize.
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{
+		MaxFrameSize: DefaultMaxFrameSize,
+		r:            r,
+	}
+}
+
+// ReadFrame reads the next frame from the stream.
+func (f *FrameReader) ReadFrame() ([]byte, error) {
+	if f.Timeout != 0 {
+		if d, ok := f.r.(interface {
+			SetReadDeadline(time.Time) error
+		}); ok {
+			if err := d.SetReadDeadline(time.Now().Add(f.Timeout)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Each Prefix is Six Bytes
+	prefixBuffer := make([]byte, len(Prefix)+4)
+	if _, err := io.ReadFull(f.r, prefixBuffer); err != nil {
+		return nil, err
+	}
+
+	// The first two bytes should contain the standard message prefix.
+	if !bytes.Equal(prefixBuffer[:len(Prefix)], Prefix) {
+		return nil, ErrInvalidPrefix
+	}
+
+	// The following four bytes contain the length of the message.
+	length := int32(binary.BigEndian.Uint32(prefixBuffer[len(Prefix):]))
+	if length == 0 {
+		return nil, ErrEmptyFrame
+	} else if length < 0 || length > f.MaxFrameSize {
+		return nil, &FrameSizeError{int64(length), f.MaxFrameSize}
+	}
+
+	// Only grow the buffer as data arrives, so that a peer cannot force a
+	// large allocation just by sending a large length.
+	buf := &bytes.Buffer{}
+	if length < frameChunkSize {
+		buf.Grow(int(length))
+	} else {
+		buf.Grow(frameChunkSize)
+	}
+
+	_, err := io.CopyN(buf, f.r, int64(length))
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// A FrameWriter writes length-prefixed AirDispatch frames to a stream.
+type FrameWriter struct {
+	// The largest frame (in bytes) that will be written.
+	MaxFrameSize int32
+	// If non-zero, and the writer has a SetWriteDeadline method, each
+	// frame must be written within Timeout.
+	Timeout time.Duration
+
+	w io.Writer
+}
+
+// NewFrameWriter creates a FrameWriter with the DefaultMaxFrameSize.
+func NewFrameWriter(w io.Writer) *FrameWriter {
+	return &FrameWriter{
+		MaxFrameSize: DefaultMaxFrameSize,
+		w:            w,
+	}
+}
+
+// WriteFrame writes data to the stream as a single frame.
+func (f *FrameWriter) WriteFrame(data []byte) error {
+	if len(data) == 0 {
+		return ErrEmptyFrame
+	} else if int64(len(data)) > int64(f.MaxFrameSize) {
+		return &FrameSizeError{int64(len(data)), f.MaxFrameSize}
+	}
+
+	if f.Timeout != 0 {
+		if d, ok := f.w.(interface {
+			SetWriteDeadline(time.Time) error
+		}); ok {
+			if err := d.SetWriteDeadline(time.Now().Add(f.Timeout)); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := f.w.Write(PrefixBytes(data))
+	return err
+}
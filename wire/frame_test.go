@@ -0,0 +1,55 @@
+package wire
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestFrameReaderMultipleFrames(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewFrameWriter(buf)
+	for _, v := range []string{"one", "two", "three"} {
+		if err := w.WriteFrame([]byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewFrameReader(buf)
+	for _, v := range []string{"one", "two", "three"} {
+		frame, err := r.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(frame) != v {
+			t.Errorf("Expected frame %s, got %s", v, frame)
+		}
+	}
+
+	if _, err := r.ReadFrame(); err != io.EOF {
+		t.Error("Expected EOF after last frame, got", err)
+	}
+}
+
+func TestFrameReaderRejectsLengths(t *testing.T) {
+	negative := []byte{'A', 'D', 0xff, 0xff, 0xff, 0xff}
+	if _, err := ReadBytes(bytes.NewReader(negative)); err == nil {
+		t.Error("Expected negative length to be rejected.")
+	} else if e, ok := err.(*FrameSizeError); !ok || e.Length >= 0 {
+		t.Error("Expected negative FrameSizeError, got", err)
+	}
+
+	r := NewFrameReader(bytes.NewReader(PrefixBytes(make([]byte, 100))))
+	r.MaxFrameSize = 10
+	if _, err := r.ReadFrame(); err == nil {
+		t.Error("Expected oversized frame to be rejected.")
+	} else if _, ok := err.(*FrameSizeError); !ok {
+		t.Error("Expected FrameSizeError, got", err)
+	}
+
+	// A large length without the data to back it up must not succeed.
+	truncated := []byte{'A', 'D', 0x01, 0x00, 0x00, 0x00, 1, 2, 3}
+	if _, err := ReadBytes(bytes.NewReader(truncated)); err != io.ErrUnexpectedEOF {
+		t.Error("Expected unexpected EOF, got", err)
+	}
+}
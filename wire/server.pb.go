@@ -117,5 +117,45 @@ func (m *MessageList) GetLength() uint64 {
 	return 0
 }
 
+type Session struct {
+	Version          *uint32 `protobuf:"varint,1,req,name=version" json:"version,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *Session) Reset()         { *m = Session{} }
+func (m *Session) String() string { return proto.CompactTextString(m) }
+func (*Session) ProtoMessage()    {}
+
+func (m *Session) GetVersion() uint32 {
+	if m != nil && m.Version != nil {
+		return *m.Version
+	}
+	return 0
+}
+
+type SessionFrame struct {
+	RequestId        *uint64 `protobuf:"varint,1,req,name=request_id" json:"request_id,omitempty"`
+	Data             []byte  `protobuf:"bytes,2,req,name=data" json:"data,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *SessionFrame) Reset()         { *m = SessionFrame{} }
+func (m *SessionFrame) String() string { return proto.CompactTextString(m) }
+func (*SessionFrame) ProtoMessage()    {}
+
+func (m *SessionFrame) GetRequestId() uint64 {
+	if m != nil && m.RequestId != nil {
+		return *m.RequestId
+	}
+	return 0
+}
+
+func (m *SessionFrame) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
 func init() {
 }
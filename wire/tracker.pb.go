@@ -0,0 +1,201 @@
+// Code generated by protoc-gen-go.
+// source: source/tracker.proto
+// DO NOT EDIT!
+
+package wire
+
+import proto "code.google.com/p/goprotobuf/proto"
+import json "encoding/json"
+import math "math"
+
+// Reference proto, json, and math imports to suppress error if they are not otherwise used.
+var _ = proto.Marshal
+var _ = &json.SyntaxError{}
+var _ = math.Inf
+
+type TrackerRegistration struct {
+	Address          []byte             `protobuf:"bytes,1,req,name=address" json:"address,omitempty"`
+	EncryptionKey    []byte             `protobuf:"bytes,2,req,name=encryption_key" json:"encryption_key,omitempty"`
+	SigningKey       []byte             `protobuf:"bytes,3,req,name=signing_key" json:"signing_key,omitempty"`
+	Location         *string            `protobuf:"bytes,4,req,name=location" json:"location,omitempty"`
+	Alias            *string            `protobuf:"bytes,5,opt,name=alias" json:"alias,omitempty"`
+	Redirect         []*TrackerRedirect `protobuf:"bytes,6,rep,name=redirect" json:"redirect,omitempty"`
+	Timestamp        *uint64            `protobuf:"varint,7,req,name=timestamp" json:"timestamp,omitempty"`
+	Expires          *uint64            `protobuf:"varint,8,req,name=expires" json:"expires,omitempty"`
+	XXX_unrecognized []byte             `json:"-"`
+}
+
+func (m *TrackerRegistration) Reset()         { *m = TrackerRegistration{} }
+func (m *TrackerRegistration) String() string { return proto.CompactTextString(m) }
+func (*TrackerRegistration) ProtoMessage()    {}
+
+func (m *TrackerRegistration) GetAddress() []byte {
+	if m != nil {
+		return m.Address
+	}
+	return nil
+}
+
+func (m *TrackerRegistration) GetEncryptionKey() []byte {
+	if m != nil {
+		return m.EncryptionKey
+	}
+	return nil
+}
+
+func (m *TrackerRegistration) GetSigningKey() []byte {
+	if m != nil {
+		return m.SigningKey
+	}
+	return nil
+}
+
+func (m *TrackerRegistration) GetLocation() string {
+	if m != nil && m.Location != nil {
+		return *m.Location
+	}
+	return ""
+}
+
+func (m *TrackerRegistration) GetAlias() string {
+	if m != nil && m.Alias != nil {
+		return *m.Alias
+	}
+	return ""
+}
+
+func (m *TrackerRegistration) GetRedirect() []*TrackerRedirect {
+	if m != nil {
+		return m.Redirect
+	}
+	return nil
+}
+
+func (m *TrackerRegistration) GetTimestamp() uint64 {
+	if m != nil && m.Timestamp != nil {
+		return *m.Timestamp
+	}
+	return 0
+}
+
+func (m *TrackerRegistration) GetExpires() uint64 {
+	if m != nil && m.Expires != nil {
+		return *m.Expires
+	}
+	return 0
+}
+
+type TrackerRedirect struct {
+	Type             *string `protobuf:"bytes,1,req,name=type" json:"type,omitempty"`
+	Address          []byte  `protobuf:"bytes,2,opt,name=address" json:"address,omitempty"`
+	Alias            *string `protobuf:"bytes,3,opt,name=alias" json:"alias,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *TrackerRedirect) Reset()         { *m = TrackerRedirect{} }
+func (m *TrackerRedirect) String() string { return proto.CompactTextString(m) }
+func (*TrackerRedirect) ProtoMessage()    {}
+
+func (m *TrackerRedirect) GetType() string {
+	if m != nil && m.Type != nil {
+		return *m.Type
+	}
+	return ""
+}
+
+func (m *TrackerRedirect) GetAddress() []byte {
+	if m != nil {
+		return m.Address
+	}
+	return nil
+}
+
+func (m *TrackerRedirect) GetAlias() string {
+	if m != nil && m.Alias != nil {
+		return *m.Alias
+	}
+	return ""
+}
+
+type TrackerRegistrationRequest struct {
+	Registration     []byte `protobuf:"bytes,1,req,name=registration" json:"registration,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *TrackerRegistrationRequest) Reset()         { *m = TrackerRegistrationRequest{} }
+func (m *TrackerRegistrationRequest) String() string { return proto.CompactTextString(m) }
+func (*TrackerRegistrationRequest) ProtoMessage()    {}
+
+func (m *TrackerRegistrationRequest) GetRegistration() []byte {
+	if m != nil {
+		return m.Registration
+	}
+	return nil
+}
+
+type TrackerQuery struct {
+	Address          []byte  `protobuf:"bytes,1,opt,name=address" json:"address,omitempty"`
+	Alias            *string `protobuf:"bytes,2,opt,name=alias" json:"alias,omitempty"`
+	Succession       *bool   `protobuf:"varint,3,opt,name=succession" json:"succession,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *TrackerQuery) Reset()         { *m = TrackerQuery{} }
+func (m *TrackerQuery) String() string { return proto.CompactTextString(m) }
+func (*TrackerQuery) ProtoMessage()    {}
+
+func (m *TrackerQuery) GetAddress() []byte {
+	if m != nil {
+		return m.Address
+	}
+	return nil
+}
+
+func (m *TrackerQuery) GetAlias() string {
+	if m != nil && m.Alias != nil {
+		return *m.Alias
+	}
+	return ""
+}
+
+func (m *TrackerQuery) GetSuccession() bool {
+	if m != nil && m.Succession != nil {
+		return *m.Succession
+	}
+	return false
+}
+
+type TrackerQueryResponse struct {
+	Registration     []byte `protobuf:"bytes,1,req,name=registration" json:"registration,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *TrackerQueryResponse) Reset()         { *m = TrackerQueryResponse{} }
+func (m *TrackerQueryResponse) String() string { return proto.CompactTextString(m) }
+func (*TrackerQueryResponse) ProtoMessage()    {}
+
+func (m *TrackerQueryResponse) GetRegistration() []byte {
+	if m != nil {
+		return m.Registration
+	}
+	return nil
+}
+
+type TrackerSuccession struct {
+	Succession       []byte `protobuf:"bytes,1,req,name=succession" json:"succession,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *TrackerSuccession) Reset()         { *m = TrackerSuccession{} }
+func (m *TrackerSuccession) String() string { return proto.CompactTextString(m) }
+func (*TrackerSuccession) ProtoMessage()    {}
+
+func (m *TrackerSuccession) GetSuccession() []byte {
+	if m != nil {
+		return m.Succession
+	}
+	return nil
+}
+
+func init() {
+}